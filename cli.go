@@ -0,0 +1,1104 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// config holds the values of the command-line flags. Each command registers
+// the groups of flags that apply to it.
+type config struct {
+	// Common flags.
+	quiet            bool
+	verbose          bool
+	timeout          time.Duration
+	extraCompression bool
+	normalizeSlashes bool
+	offset           int64
+	length           int64
+	mmap             bool
+	fromTar          string
+	member           string
+	logFormat        string
+	logLevel         string
+
+	// Hash flags.
+	hashAlgo     string
+	hashFormat   string
+	expectedHash string
+	sumLine      string
+	sumMod       module.Version
+	goSum        string
+	noSum        string
+	// reportHash is set if the expected hash was given explicitly, but empty,
+	// in which case the computed hash is printed to stderr instead.
+	reportHash bool
+
+	// Output flags.
+	jsonOutput bool
+	filesHash  bool
+	out        string
+
+	// Legacy flags.
+	list        bool
+	print0      bool
+	checkOnly   bool
+	extractPath string
+
+	// Check flags.
+	maxSize       byteSize
+	entryLimit    int
+	maxPathLength int
+	allowEmpty    bool
+	requireGoMod  bool
+	canonical     bool
+	goModPrefix   string
+	expectedMod   module.Version
+	strict        bool
+	caseSensitive bool
+	rules         ruleList
+	maxRatio      float64
+	methods       methodList
+	ratios        bool
+
+	// Extract flags.
+	mode            string
+	uniformMode     string
+	dirMode         fileMode
+	jobs            int
+	rateLimit       byteSize
+	stripComponents int
+	rewrites        rewriteList
+	cacheLayout     bool
+	hashSubdir      bool
+	include         string
+	exclude         stringList
+	metadataOnly    bool
+	preserveMtime   bool
+	relativeTo      string
+	checksumFile    string
+	escape          bool
+	manifest        string
+	force           bool
+	skipIfExtracted bool
+	fsync           bool
+	continueOnError bool
+	tarOut          string
+	dryRun          bool
+	progress        bool
+}
+
+func (c *config) registerCommon(fs *flag.FlagSet) {
+	fs.BoolVar(&c.quiet, "quiet", false, "don't print the hash or any error messages, only set the exit code")
+	fs.BoolVar(&c.verbose, "v", false, "print the omitted or extracted files, a summary and the time spent in each phase to stderr")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Minute, "timeout for each attempt to download <zip> if it is an HTTP(S) URL")
+	fs.BoolVar(&c.extraCompression, "allow-extra-compression", false, "accept files compressed with zstd or xz in addition to store and deflate")
+	fs.BoolVar(&c.normalizeSlashes, "normalize-slashes", false, "replace backslashes in the paths of files by forward slashes instead of rejecting them, for zips created by some Windows tools")
+	fs.Int64Var(&c.offset, "offset", 0, "read the zip from the given byte `offset` in <zip>, e.g., in a pack of concatenated zips")
+	fs.Int64Var(&c.length, "length", 0, "read the zip from the given number of `bytes` in <zip>, or up to its end if 0")
+	fs.StringVar(&c.fromTar, "from-tar", "", "read the zip from the member given by -member of the tar archive at `path`, which may be gzip-compressed, in which case <zip> is omitted")
+	fs.StringVar(&c.member, "member", "", "`name` of the member of the -from-tar archive that contains the zip, which may be at most -max-size (default 500M) large")
+	fs.BoolVar(&c.mmap, "mmap", false, "read zips of at least 64M through a memory mapping, which can be faster for large zips")
+	fs.StringVar(&c.logFormat, "log-format", "plain", "`format` of warnings and errors on stderr: plain, or text or json for structured logs as written by log/slog")
+	fs.StringVar(&c.logLevel, "log-level", "", "minimum `level` of the messages written to stderr: debug, info, warn or error; the default is info, or debug with -v")
+}
+
+func (c *config) registerHash(fs *flag.FlagSet) {
+	fs.StringVar(&c.hashAlgo, "hash-algo", "h1", "hash to compute and compare: h1 (content hash of the files) or sha256 (hex digest of the zip file)")
+	fs.StringVar(&c.hashFormat, "hash-format", "h1", "format of the h1 hash: h1 (h1: followed by base64), hex or base64raw (unpadded base64)")
+}
+
+// registerOut registers the -out flag of the commands that print the hash.
+func (c *config) registerOut(fs *flag.FlagSet) {
+	fs.StringVar(&c.out, "out", "", "write the hash to the file at `path` instead of stdout, replacing it atomically")
+}
+
+// registerVerify registers the flags that specify the expected hash. The
+// legacy command line takes the expected hash as a positional argument instead
+// of the -hash flag.
+func (c *config) registerVerify(fs *flag.FlagSet, hashFlag bool) {
+	if hashFlag {
+		fs.StringVar(&c.expectedHash, "hash", "", "expected `hash` of the zip file, or env:VARNAME to read it from an environment variable; if given but empty, the computed hash is printed to stderr instead")
+		fs.StringVar(&c.goSum, "gosum", "", "go.sum `file` with the expected hash of the module given by -module and -version")
+		fs.StringVar(&c.sumMod.Path, "module", "", "module `path` to look up in the -gosum file")
+		fs.StringVar(&c.sumMod.Version, "version", "", "module `version` to look up in the -gosum file or to combine with -gomod-prefix")
+	}
+	fs.StringVar(&c.sumLine, "sumline", "", "go.sum `line` with the expected hash")
+	fs.StringVar(&c.noSum, "nosum-prefixes", "", "comma-separated glob `patterns` of module path prefixes, as in GONOSUMDB, for which the hash isn't compared")
+}
+
+func (c *config) registerCheck(fs *flag.FlagSet) {
+	c.maxSize = contenthash.MaxZipFile
+	fs.Var(&c.maxSize, "max-size", "maximum `size` of the zip file and of its uncompressed contents, with an optional K, M, G or T suffix")
+	fs.IntVar(&c.entryLimit, "entry-limit", contenthash.MaxZipEntries, "maximum number of entries in the zip file, or 0 for no limit")
+	fs.IntVar(&c.maxPathLength, "max-path-length", contenthash.MaxPathLength, "maximum length in `bytes` of the path of each entry, or 0 for no limit; on Windows, extracted paths are also limited to MAX_PATH")
+	fs.BoolVar(&c.allowEmpty, "allow-empty", false, "accept zip files that contain no files")
+	fs.BoolVar(&c.requireGoMod, "require-gomod", false, "require a go.mod file directly below the module@version prefix")
+	fs.BoolVar(&c.canonical, "canonical", false, "require all files to be contained in a single valid module@version directory")
+	fs.StringVar(&c.goModPrefix, "gomod-prefix", "", "require all files to be contained in the module@version directory of the module declared in the go.mod `file` and the version given by -version, and strip it if no prefix is given")
+	fs.BoolVar(&c.strict, "strict", false, "report or reject conditions that are otherwise ignored, such as a zip comment")
+	fs.BoolVar(&c.caseSensitive, "case-sensitive", false, "accept paths that only differ in case, which can't be extracted on case-insensitive file systems")
+	fs.Float64Var(&c.maxRatio, "max-ratio", 0, "reject files whose uncompressed size is more than `ratio` times their compressed size, or 0 for no limit")
+	fs.Var(&c.methods, "allowed-methods", "comma-separated compression `methods` that files may use, out of store, deflate and, with -allow-extra-compression, zstd and xz; all of these by default")
+	fs.Var(&c.rules, "rule", "additionally reject files that violate the `rule`, one of "+strings.Join(ruleNames(), ", ")+"; may be repeated")
+}
+
+func (c *config) registerExtract(fs *flag.FlagSet) {
+	fs.StringVar(&c.mode, "mode", contenthash.ModeReadOnly.String(), "permissions of extracted files: readonly (like the module cache), writable or preserve (use the modes stored in the zip)")
+	c.dirMode = 0755
+	fs.StringVar(&c.uniformMode, "uniform-mode", "", "give all extracted files exactly the octal permissions `mode` regardless of the zip and the umask; takes precedence over -mode")
+	fs.Var(&c.dirMode, "dir-mode", "octal permissions of created directories, including the target directory and its missing parents, regardless of the umask")
+	fs.IntVar(&c.jobs, "j", 1, "number of files to extract concurrently")
+	fs.Var(&c.rateLimit, "rate-limit", "maximum number of `bytes` written per second, with an optional K, M, G or T suffix, or 0 for no limit")
+	fs.IntVar(&c.stripComponents, "strip-components", 0, "remove `N` leading path elements from extracted files after stripping the prefix")
+	fs.Var(&c.rewrites, "rewrite", "replace the prefix FROM of the paths of extracted files by TO, given as `FROM:TO`, after stripping the prefix and path elements; may be repeated and the first matching rule applies")
+	fs.BoolVar(&c.cacheLayout, "cache-layout", false, "extract the files to <dir>/<module>@<version> like in the module cache instead of stripping a prefix")
+	fs.BoolVar(&c.hashSubdir, "hash-subdir", false, "extract the files to the subdirectory of <dir> named after the h1 hash of the zip, e.g., <dir>/h1-<digest>, for a content-addressed cache; with -skip-if-extracted, an existing subdirectory that contains the files is a cache hit")
+	fs.StringVar(&c.include, "include", "", "only extract the files whose paths after stripping the prefix are listed in the `file`, one per line")
+	fs.Var(&c.exclude, "exclude", "don't extract files whose paths after stripping the prefix, or one of their parent directories, match the `pattern`; may be repeated and takes precedence over -include")
+	fs.BoolVar(&c.metadataOnly, "metadata-only", false, "only extract the go.mod and go.sum files at the root after stripping the prefix, but still check the whole zip")
+	fs.BoolVar(&c.preserveMtime, "preserve-mtime", false, "set the modification times of extracted files to those stored in the zip instead of the current time")
+	fs.BoolVar(&c.force, "force", false, "replace the contents of the target directory if it isn't empty")
+	fs.BoolVar(&c.skipIfExtracted, "skip-if-extracted", false, "succeed without extracting anything if the target directory already contains the module@version directory or, after stripping the prefix, the top-level files of the zip, after checking the zip and its hash; other contents are still rejected unless -force is given")
+	fs.BoolVar(&c.fsync, "fsync", false, "flush extracted files and directories to disk before moving them into place")
+	fs.BoolVar(&c.continueOnError, "continue-on-error", false, "attempt to extract all files and report all failures instead of stopping at the first")
+	fs.BoolVar(&c.dryRun, "dry-run", false, "decompress all files and verify their sizes without writing anything")
+	fs.BoolVar(&c.progress, "progress", false, "print the extraction progress to stderr")
+	fs.BoolVar(&c.escape, "escape-collisions", false, "extract files whose paths only differ in case under a path with a hash suffix instead of rejecting them")
+	fs.StringVar(&c.manifest, "manifest", "", "write a JSON array with the name in the zip, the extracted path relative to <dir>, the size, the mode and the SHA-256 digest of each extracted file to `path`")
+	fs.StringVar(&c.checksumFile, "checksum-file", "", "write the SHA-256 digest and slash-separated path of each extracted file, relative to <dir>, to `path` in the format of sha256sum")
+	fs.StringVar(&c.relativeTo, "relative-to", "", "print the paths of extracted files relative to the `root` directory instead of as given")
+}
+
+// quietErr wraps err so that it is only reported through the exit code if
+// -quiet is set.
+func (c *config) quietErr(err error) error {
+	if err != nil && c.quiet {
+		return quietError{err}
+	}
+	return err
+}
+
+// envHashPrefix marks an expected hash that is read from the environment
+// variable whose name follows it.
+const envHashPrefix = "env:"
+
+// resolveEnvHash replaces an expected hash of the form env:VARNAME by the
+// value of the environment variable VARNAME, which keeps the hash out of the
+// argument list visible to other processes.
+func (c *config) resolveEnvHash() error {
+	name, ok := strings.CutPrefix(c.expectedHash, envHashPrefix)
+	if !ok {
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("missing environment variable name in expected hash %q", c.expectedHash)
+	}
+	hash := strings.TrimSpace(os.Getenv(name))
+	if hash == "" {
+		return fmt.Errorf("environment variable %s with the expected hash is unset or empty", name)
+	}
+	c.expectedHash = hash
+	return nil
+}
+
+// resolveSumLine sets the expected hash from -sumline or -gosum, if given.
+func (c *config) resolveSumLine() error {
+	if c.goSum != "" {
+		return c.resolveGoSum()
+	}
+	if c.sumLine == "" {
+		return nil
+	}
+	if c.expectedHash != "" {
+		return errors.New("-sumline and an expected hash are mutually exclusive")
+	}
+	if c.hashAlgo != "h1" || c.hashFormat != "h1" {
+		return errors.New("-sumline requires -hash-algo=h1 and -hash-format=h1")
+	}
+	mod, hash, err := contenthash.ParseSumLine(c.sumLine)
+	if err != nil {
+		return err
+	}
+	c.sumMod = mod
+	c.expectedHash = hash
+	return nil
+}
+
+// resolveGoSum sets the expected hash from the line for -module and -version
+// in the -gosum file.
+func (c *config) resolveGoSum() error {
+	if c.expectedHash != "" || c.sumLine != "" {
+		return errors.New("-gosum, -sumline and an expected hash are mutually exclusive")
+	}
+	if c.sumMod.Path == "" || c.sumMod.Version == "" {
+		return errors.New("-gosum requires -module and -version")
+	}
+	if c.hashAlgo != "h1" || c.hashFormat != "h1" {
+		return errors.New("-gosum requires -hash-algo=h1 and -hash-format=h1")
+	}
+	hash, err := contenthash.LookupSum(c.goSum, c.sumMod)
+	if err != nil {
+		return err
+	}
+	c.expectedHash = hash
+	return nil
+}
+
+// resolveGoModPrefix sets the expected module from the module path declared in
+// the -gomod-prefix file and -version, if given.
+func (c *config) resolveGoModPrefix() error {
+	if c.goModPrefix == "" {
+		return nil
+	}
+	if c.sumMod.Version == "" {
+		return errors.New("-gomod-prefix requires -version")
+	}
+	data, err := os.ReadFile(c.goModPrefix)
+	if err != nil {
+		return err
+	}
+	modPath := modfile.ModulePath(data)
+	if modPath == "" {
+		return fmt.Errorf("%s: no module directive found", c.goModPrefix)
+	}
+	if c.sumMod.Path != "" && c.sumMod.Path != modPath {
+		return fmt.Errorf("-module %s differs from the module %s declared in %s", c.sumMod.Path, modPath, c.goModPrefix)
+	}
+	c.expectedMod = module.Version{Path: modPath, Version: c.sumMod.Version}
+	return nil
+}
+
+// defaultPrefix returns prefix or, if it is empty and -gomod-prefix is given,
+// the prefix that strips the module@version directory.
+func (c *config) defaultPrefix(prefix string) string {
+	if prefix == "" && c.expectedMod.Path != "" && !c.cacheLayout {
+		return contenthash.AutoPrefix
+	}
+	return prefix
+}
+
+// checkHash returns an error if hash differs from the expected hash.
+func (c *config) checkHash(hash string) error {
+	if hash == c.expectedHash {
+		return nil
+	}
+	err := error(&contenthash.HashMismatchError{Got: hash, Want: c.expectedHash})
+	if c.sumLine != "" || c.goSum != "" {
+		err = fmt.Errorf("%s: %w", c.sumMod, err)
+	}
+	return err
+}
+
+// skipHash reports whether the hash of z shouldn't be compared since its module
+// path matches -nosum-prefixes. Zip files that don't contain a single module
+// are always compared.
+func (c *config) skipHash(z *zip.Reader) bool {
+	if c.noSum == "" {
+		return false
+	}
+	modPath, err := contenthash.ModulePath(z)
+	if err != nil || !module.MatchPrefixPatterns(c.noSum, modPath) {
+		return false
+	}
+	if !c.quiet {
+		slog.Info(fmt.Sprintf("skipping hash check for %s, which matches -nosum-prefixes", modPath), "module", modPath)
+	}
+	return true
+}
+
+// skipHashFile is like skipHash, but reads the zip file at zipFile.
+func (c *config) skipHashFile(zipFile string) (bool, error) {
+	if c.noSum == "" {
+		return false, nil
+	}
+	f, r, err := c.openZip(zipFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	z, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		// Leave the error to the checks of the zip file.
+		return false, nil
+	}
+	return c.skipHash(z), nil
+}
+
+// hashOptions returns options that make contenthash.Unzip verify that the zip
+// file has the expected hash, if any. SHA-256 digests of the zip file are
+// verified immediately.
+func (c *config) hashOptions(zipFile string) ([]contenthash.Option, error) {
+	if c.expectedHash == "" {
+		return c.reportHashOptions(zipFile)
+	}
+	if skip, err := c.skipHashFile(zipFile); err != nil || skip {
+		return nil, err
+	}
+	if c.hashAlgo == "sha256" {
+		hash, err := c.computeHash(zipFile)
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.checkHash(hash)
+	}
+	h1, err := parseHash(c.expectedHash, c.hashFormat)
+	if err != nil {
+		return nil, err
+	}
+	return []contenthash.Option{contenthash.WithExpectedHash(h1)}, nil
+}
+
+// reportHashOptions returns options that make contenthash.Unzip print the
+// computed hash to stderr if an empty expected hash was given, so that stdout
+// stays clean, e.g., for extracting a zip file and learning its hash at once.
+func (c *config) reportHashOptions(zipFile string) ([]contenthash.Option, error) {
+	if !c.reportHash || c.quiet {
+		return nil, nil
+	}
+	if c.hashAlgo == "sha256" {
+		hash, err := c.computeHash(zipFile)
+		if err != nil {
+			return nil, err
+		}
+		c.logHash(hash)
+		return nil, nil
+	}
+	// Reject an unsupported -hash-format before extracting anything.
+	if _, err := formatHash("h1:", c.hashFormat); err != nil {
+		return nil, err
+	}
+	return []contenthash.Option{contenthash.WithOnHash(func(h1 string) {
+		hash, _ := formatHash(h1, c.hashFormat)
+		c.logHash(hash)
+	})}, nil
+}
+
+// logHash prints the computed hash to stderr if an empty expected hash was
+// given, unless -quiet is set.
+func (c *config) logHash(hash string) {
+	if !c.quiet {
+		slog.Info(hash, "hash", hash)
+	}
+}
+
+// reportHashMismatch rewrites hash mismatches reported by contenthash in the
+// format selected by the flags.
+func (c *config) reportHashMismatch(err error) error {
+	var mismatch *contenthash.HashMismatchError
+	if !errors.As(err, &mismatch) {
+		return err
+	}
+	hash, formatErr := formatHash(mismatch.Got, c.hashFormat)
+	if formatErr != nil {
+		return err
+	}
+	return c.checkHash(hash)
+}
+
+// readOptions returns the options that affect how the files in the zip file
+// are read.
+func (c *config) readOptions() []contenthash.Option {
+	var opts []contenthash.Option
+	if c.extraCompression {
+		opts = append(opts, contenthash.WithExtraCompression())
+	}
+	if c.normalizeSlashes {
+		opts = append(opts, contenthash.WithNormalizeSlashes())
+	}
+	if c.offset != 0 || c.length != 0 {
+		opts = append(opts, contenthash.WithSection(c.offset, c.length))
+	}
+	if c.mmap {
+		opts = append(opts, contenthash.WithMmap())
+	}
+	return opts
+}
+
+func (c *config) checkOptions() []contenthash.Option {
+	opts := append(c.readOptions(),
+		contenthash.WithMaxSize(int64(c.maxSize)),
+		contenthash.WithMaxEntries(c.entryLimit),
+		contenthash.WithMaxPathLength(c.maxPathLength),
+	)
+	if c.strict {
+		opts = append(opts, contenthash.WithStrict())
+	}
+	if c.allowEmpty {
+		opts = append(opts, contenthash.WithAllowEmpty())
+	}
+	if c.requireGoMod {
+		opts = append(opts, contenthash.WithRequireGoMod())
+	}
+	if c.canonical {
+		opts = append(opts, contenthash.WithCanonical())
+	}
+	if c.expectedMod.Path != "" {
+		opts = append(opts, contenthash.WithExpectedModule(c.expectedMod))
+	}
+	if c.caseSensitive {
+		opts = append(opts, contenthash.WithCaseSensitive())
+	}
+	if c.maxRatio > 0 {
+		opts = append(opts, contenthash.WithMaxRatio(c.maxRatio))
+	}
+	if !c.quiet {
+		opts = append(opts, contenthash.WithOnWarning(func(msg string) {
+			slog.Warn(msg)
+		}))
+	}
+	for _, v := range c.rules.validators() {
+		opts = append(opts, contenthash.WithValidator(v))
+	}
+	if len(c.methods) > 0 {
+		opts = append(opts, contenthash.WithAllowedMethods(c.methods...))
+	}
+	return opts
+}
+
+func (c *config) extractOptions() ([]contenthash.Option, error) {
+	policy, err := contenthash.ParseModePolicy(c.mode)
+	if err != nil {
+		return nil, err
+	}
+	opts := append(c.checkOptions(),
+		contenthash.WithModePolicy(policy),
+		contenthash.WithDirMode(os.FileMode(c.dirMode)),
+		contenthash.WithJobs(c.jobs),
+		contenthash.WithRateLimit(int64(c.rateLimit)),
+		contenthash.WithStripComponents(c.stripComponents),
+	)
+	if c.uniformMode != "" {
+		var perm fileMode
+		if err := perm.Set(c.uniformMode); err != nil {
+			return nil, fmt.Errorf("-uniform-mode: %w", err)
+		}
+		opts = append(opts, contenthash.WithUniformMode(os.FileMode(perm)))
+	}
+	for _, r := range c.rewrites {
+		opts = append(opts, contenthash.WithRewrite(r.from, r.to))
+	}
+	if c.cacheLayout {
+		opts = append(opts, contenthash.WithCacheLayout())
+	}
+	if c.hashSubdir {
+		opts = append(opts, contenthash.WithHashSubdir())
+	}
+	if c.include != "" {
+		paths, err := readLines(c.include)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, contenthash.WithInclude(paths))
+	}
+	if len(c.exclude) > 0 {
+		opts = append(opts, contenthash.WithExclude(c.exclude))
+	}
+	if c.metadataOnly {
+		opts = append(opts, contenthash.WithMetadataOnly())
+	}
+	if c.preserveMtime {
+		opts = append(opts, contenthash.WithPreserveMtime())
+	}
+	if c.escape {
+		opts = append(opts, contenthash.WithEscapeCollisions())
+	}
+	if c.force {
+		opts = append(opts, contenthash.WithForce())
+	}
+	if c.skipIfExtracted {
+		opts = append(opts, contenthash.WithSkipIfExtracted())
+	}
+	if c.fsync {
+		opts = append(opts, contenthash.WithFsync())
+	}
+	if c.continueOnError {
+		opts = append(opts, contenthash.WithContinueOnError())
+	}
+	if c.dryRun {
+		opts = append(opts, contenthash.WithDryRun())
+	}
+	return opts, nil
+}
+
+// hash prints the hash of zipFile, or the lines it is computed from if
+// filesHash is set.
+func (c *config) hash(zipFile string, filesHash bool) error {
+	if filesHash {
+		lines, err := contenthash.FileHashes(zipFile, c.readOptions()...)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	start := time.Now()
+	hash, err := c.computeHash(zipFile)
+	if err != nil {
+		return err
+	}
+	c.printStats(contenthash.Stats{Hash: time.Since(start)})
+	return c.printHash(hash)
+}
+
+// hashDir prints the hash of the files in dir as if they were below prefix in
+// a zip file or, if a hash is expected, verifies it.
+func (c *config) hashDir(dir, prefix string) error {
+	if c.hashAlgo != "h1" {
+		return errors.New("hashdir only supports -hash-algo=h1")
+	}
+	hash, err := contenthash.HashDir(dir, prefix)
+	if err != nil {
+		return err
+	}
+	if hash, err = formatHash(hash, c.hashFormat); err != nil {
+		return err
+	}
+	if c.expectedHash != "" {
+		return c.checkHash(hash)
+	}
+	return c.printHash(hash)
+}
+
+// hashAll prints the hash and path of each zip file in args, separated by a
+// tab and in the order of args, hashing up to -j zip files concurrently.
+// Errors are reported for each zip file, including failures to download or
+// decompress it, without stopping the others.
+func (c *config) hashAll(ctx context.Context, args []string) error {
+	type result struct {
+		hash string
+		err  error
+		done chan struct{}
+	}
+	results := make([]result, len(args))
+	for i := range results {
+		results[i].done = make(chan struct{})
+	}
+	work := make(chan int)
+	go func() {
+		defer close(work)
+		for i := range args {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	jobs := c.jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	for j := 0; j < jobs; j++ {
+		go func() {
+			for i := range work {
+				r := &results[i]
+				r.hash, r.err = c.hashArg(ctx, args, i)
+				close(r.done)
+			}
+		}()
+	}
+
+	var failed int
+	for i, arg := range args {
+		r := &results[i]
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			failed++
+			if !c.quiet {
+				slog.Error(fmt.Sprintf("%s: %v", arg, r.err), "zip", arg)
+			}
+			continue
+		}
+		if !c.quiet {
+			fmt.Printf("%s\t%s\n", r.hash, arg)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to hash %d of %d zip files", failed, len(args))
+	}
+	return nil
+}
+
+// hashArg computes the hash of the zip file args[i]. With -from-tar, args[0]
+// is the tar archive that contains the zip.
+func (c *config) hashArg(ctx context.Context, args []string, i int) (string, error) {
+	localZip := c.localZip
+	if i == 0 {
+		localZip = c.inputZip
+	}
+	zipFile, cleanup, err := localZip(ctx, args[i])
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	return c.computeHash(zipFile)
+}
+
+// printHash writes hash to the file given by -out, or prints it to stdout
+// unless -quiet is set.
+func (c *config) printHash(hash string) error {
+	if c.out != "" && c.out != "-" {
+		return writeFileAtomic(c.out, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, hash)
+			return err
+		})
+	}
+	if !c.quiet {
+		fmt.Println(hash)
+	}
+	return nil
+}
+
+// checkOutput selects what check prints to stdout.
+type checkOutput int
+
+const (
+	outputNone checkOutput = iota
+	outputHash
+	outputList
+	outputFilesHash
+	outputJSON
+	outputStat
+)
+
+// check checks zipFile, verifies its hash if one is expected and prints the
+// given output.
+func (c *config) check(zipFile string, output checkOutput) error {
+	f, r, err := c.openZip(zipFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	start := time.Now()
+	z, cf, checkErr := contenthash.CheckZip(r, r.Size(), c.checkOptions()...)
+	stats := contenthash.Stats{Check: time.Since(start)}
+	if z != nil && c.verbose {
+		stats.Entries = len(z.File)
+		for _, zf := range z.File {
+			stats.Bytes += int64(zf.UncompressedSize64)
+		}
+	}
+	if z != nil {
+		c.printRatios(z)
+	}
+	if output == outputNone && c.expectedHash == "" && !c.reportHash {
+		c.printSummary(cf)
+		c.printStats(stats)
+		return checkErr
+	}
+	// z is nil if the zip file couldn't be read at all, in which case checkErr
+	// explains why.
+	var hash string
+	if z != nil {
+		start = time.Now()
+		if c.hashAlgo == "h1" {
+			hash, err = contenthash.HashFiles(z)
+			if err == nil {
+				hash, err = formatHash(hash, c.hashFormat)
+			}
+		} else {
+			hash, err = c.computeHash(zipFile)
+		}
+		if err != nil {
+			return err
+		}
+		stats.Hash = time.Since(start)
+		if c.expectedHash != "" && !c.skipHash(z) {
+			if err := c.checkHash(hash); err != nil {
+				return err
+			}
+		}
+		// The hash is already printed to stdout with outputHash.
+		if c.expectedHash == "" && c.reportHash && output != outputHash {
+			c.logHash(hash)
+		}
+	}
+	c.printSummary(cf)
+	c.printStats(stats)
+	switch output {
+	case outputJSON:
+		if err := printReport(hash, cf); err != nil {
+			return err
+		}
+		return checkErr
+	case outputList:
+		if checkErr == nil {
+			printList(z, cf, c.print0)
+		}
+	case outputFilesHash:
+		if checkErr == nil {
+			return c.hash(zipFile, true)
+		}
+	case outputStat:
+		if checkErr == nil {
+			return printArchiveStats(z, c.jsonOutput)
+		}
+	case outputHash:
+		if checkErr == nil {
+			if err := c.printHash(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return checkErr
+}
+
+// printSummary prints the omitted files, details about case-insensitive
+// collisions, the number of files in each category and their common prefix if
+// -v is set.
+func (c *config) printSummary(cf contenthash.CheckedFiles) {
+	if !c.verbose {
+		return
+	}
+	for _, e := range cf.Omitted {
+		slog.Debug(fmt.Sprintf("omitted %s", e), "path", e.Path, "reason", e.Err)
+	}
+	for _, e := range cf.Invalid {
+		var collision *contenthash.CollisionError
+		if errors.As(e.Err, &collision) {
+			slog.Debug(fmt.Sprintf("%q and %q both fold to %q", collision.Other, collision.Path, collision.Folded), "path", collision.Path, "other", collision.Other, "folded", collision.Folded)
+		}
+	}
+	slog.Debug(fmt.Sprintf("%d valid, %d omitted, %d invalid files", len(cf.Valid), len(cf.Omitted), len(cf.Invalid)),
+		"valid", len(cf.Valid), "omitted", len(cf.Omitted), "invalid", len(cf.Invalid))
+	if cf.Unsorted != nil {
+		slog.Debug(cf.Unsorted.Error())
+	}
+	if prefix := cf.CommonPrefix(); prefix != "" {
+		slog.Debug("prefix: "+prefix, "prefix", prefix)
+	} else {
+		slog.Debug("prefix: none, the files don't share a common directory")
+	}
+}
+
+// warnRatio is the compression ratio above which files are reported as
+// suspicious by -ratios and -v.
+const warnRatio = 1000
+
+// printRatios prints the compression ratio of each file if -ratios is set and
+// warns about files with a suspicious ratio if -ratios or -v is set.
+func (c *config) printRatios(z *zip.Reader) {
+	if !c.ratios && !c.verbose {
+		return
+	}
+	for _, zf := range z.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		ratio := contenthash.CompressionRatio(zf)
+		switch {
+		case ratio > warnRatio:
+			slog.Warn(fmt.Sprintf("%s has a suspicious compression ratio of %.0f:1", zf.Name, ratio), "path", zf.Name, "ratio", ratio)
+		case c.ratios:
+			slog.Info(fmt.Sprintf("%.1f:1\t%s", ratio, zf.Name), "path", zf.Name, "ratio", ratio)
+		}
+	}
+}
+
+// printStats prints the number of entries and bytes in the zip file and the
+// duration of each phase if -v is set.
+func (c *config) printStats(stats contenthash.Stats) {
+	if !c.verbose {
+		return
+	}
+	var phases []string
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"check", stats.Check},
+		{"hash", stats.Hash},
+		{"extract", stats.Extract},
+	} {
+		if d := p.d.Round(time.Microsecond); d > 0 {
+			phases = append(phases, fmt.Sprintf("%s %v", p.name, d))
+		}
+	}
+	if stats.Entries > 0 {
+		slog.Debug(fmt.Sprintf("%d entries (%d bytes)", stats.Entries, stats.Bytes), "entries", stats.Entries, "bytes", stats.Bytes)
+	}
+	slog.Debug("took "+strings.Join(phases, ", "), "check", stats.Check, "hash", stats.Hash, "extract", stats.Extract)
+}
+
+// extract extracts the files below prefix in zipFile to dir.
+func (c *config) extract(ctx context.Context, zipFile, dir, prefix string) error {
+	prefix = c.defaultPrefix(prefix)
+	opts, err := c.extractOptions()
+	if err != nil {
+		return err
+	}
+	hashOpts, err := c.hashOptions(zipFile)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, hashOpts...)
+
+	var files, bytes int64
+	var extracted []contenthash.ExtractedFile
+	record := c.checksumFile != "" || c.manifest != ""
+	if record {
+		opts = append(opts, contenthash.WithSHA256())
+	}
+	opts = append(opts, contenthash.WithOnExtract(func(f contenthash.ExtractedFile) {
+		files++
+		bytes += f.Size
+		if record {
+			extracted = append(extracted, f)
+		}
+		if c.verbose {
+			p := c.displayPath(filepath.Join(dir, filepath.FromSlash(f.Path)))
+			slog.Debug(p, "path", p)
+		}
+	}))
+	var stats contenthash.Stats
+	opts = append(opts, contenthash.WithStats(func(s contenthash.Stats) {
+		stats = s
+		c.printStats(s)
+	}))
+	var progress *progressPrinter
+	if c.progress {
+		progress = newProgressPrinter(os.Stderr)
+		opts = append(opts, contenthash.WithProgress(progress.update))
+	}
+	start := time.Now()
+	err = c.reportHashMismatch(contenthash.UnzipContext(ctx, dir, zipFile, prefix, opts...))
+	if progress != nil {
+		progress.done()
+	}
+	if err != nil {
+		return err
+	}
+	if c.checksumFile != "" {
+		if err := writeChecksums(c.checksumFile, extracted); err != nil {
+			return err
+		}
+	}
+	if c.manifest != "" {
+		if err := writeManifest(c.manifest, extracted); err != nil {
+			return err
+		}
+	}
+	if !c.quiet && !stats.Skipped {
+		took := time.Since(start).Round(time.Microsecond)
+		if c.dryRun {
+			slog.Info(fmt.Sprintf("verified %d files (%d bytes) in %v", files, bytes, took), "files", files, "bytes", bytes, "took", took)
+		} else {
+			slog.Info(fmt.Sprintf("extracted %d files (%d bytes) to %s in %v", files, bytes, c.displayPath(dir), took), "files", files, "bytes", bytes, "dir", dir, "took", took)
+		}
+	}
+	if c.verbose {
+		if skipped := stats.Bytes - stats.Written; skipped > 0 {
+			slog.Debug(fmt.Sprintf("skipped %d bytes in files outside of the prefix or excluded by filters", skipped), "skipped", skipped)
+		}
+	}
+	return nil
+}
+
+// displayPath returns p relative to -relative-to, if given. p is returned
+// unchanged if it can't be made relative.
+func (c *config) displayPath(p string) string {
+	if c.relativeTo == "" {
+		return p
+	}
+	root, err := filepath.Abs(c.relativeTo)
+	if err != nil {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return p
+	}
+	return rel
+}
+
+// extractTar writes the files below prefix in zipFile to the tar archive out,
+// which is gzip-compressed if its name ends in .tar.gz or .tgz. If out is "-",
+// an uncompressed archive is written to stdout.
+func (c *config) extractTar(ctx context.Context, zipFile, out, prefix string) error {
+	if c.checksumFile != "" || c.manifest != "" {
+		return errors.New("-checksum-file and -manifest are not supported with -o")
+	}
+	if c.hashSubdir || c.cacheLayout {
+		return errors.New("-hash-subdir and -cache-layout are not supported with -o")
+	}
+	prefix = c.defaultPrefix(prefix)
+	opts, err := c.extractOptions()
+	if err != nil {
+		return err
+	}
+	hashOpts, err := c.hashOptions(zipFile)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, hashOpts...)
+	if c.verbose {
+		opts = append(opts, contenthash.WithOnExtract(func(f contenthash.ExtractedFile) {
+			slog.Debug(f.Path, "path", f.Path)
+		}))
+	}
+	if out == "-" {
+		return c.reportHashMismatch(contenthash.UnzipTar(ctx, os.Stdout, zipFile, prefix, opts...))
+	}
+	return c.reportHashMismatch(writeFileAtomic(out, func(w io.Writer) error {
+		if !strings.HasSuffix(out, ".tar.gz") && !strings.HasSuffix(out, ".tgz") {
+			return contenthash.UnzipTar(ctx, w, zipFile, prefix, opts...)
+		}
+		gw := gzip.NewWriter(w)
+		if err := contenthash.UnzipTar(ctx, gw, zipFile, prefix, opts...); err != nil {
+			return err
+		}
+		return gw.Close()
+	}))
+}
+
+// cat writes the file at path, relative to prefix, in zipFile to stdout.
+func (c *config) cat(zipFile, path, prefix string) error {
+	prefix = c.defaultPrefix(prefix)
+	opts := c.checkOptions()
+	hashOpts, err := c.hashOptions(zipFile)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, hashOpts...)
+	return c.reportHashMismatch(contenthash.UnzipFile(os.Stdout, zipFile, prefix, path, opts...))
+}
+
+// canonicalize writes a canonical version of zipFile to out, or to stdout if out
+// is "-".
+func (c *config) canonicalize(zipFile, out string) error {
+	opts := c.checkOptions()
+	hashOpts, err := c.hashOptions(zipFile)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, hashOpts...)
+	if out == "-" {
+		return c.reportHashMismatch(contenthash.Canonicalize(os.Stdout, zipFile, opts...))
+	}
+	return c.reportHashMismatch(writeFileAtomic(out, func(w io.Writer) error {
+		return contenthash.Canonicalize(w, zipFile, opts...)
+	}))
+}
+
+// diff prints the files that differ between zipA and zipB, which are shown as
+// nameA and nameB, and returns an error if there are any.
+func (c *config) diff(zipA, zipB, nameA, nameB string) error {
+	diffs, err := contenthash.Diff(zipA, zipB, c.readOptions()...)
+	if err != nil {
+		return err
+	}
+	if c.verbose {
+		for _, p := range [][2]string{{zipA, nameA}, {zipB, nameB}} {
+			hash, err := c.computeHash(p[0])
+			if err != nil {
+				return err
+			}
+			slog.Debug(fmt.Sprintf("%s %s", hash, p[1]), "hash", hash, "zip", p[1])
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	if !c.quiet {
+		for _, d := range diffs {
+			fmt.Printf("%s\t%s\n", d.Change, d.Name)
+		}
+	}
+	return fmt.Errorf("%s and %s differ in %d files", nameA, nameB, len(diffs))
+}
+
+// openZip opens zipFile and returns a reader for the section given by -offset
+// and -length, which is the whole file by default. The caller must close f.
+func (c *config) openZip(zipFile string) (f io.Closer, r *io.SectionReader, err error) {
+	f, r, err = contenthash.Open(zipFile, c.readOptions()...)
+	var pathErr *fs.PathError
+	if err != nil && !errors.As(err, &pathErr) {
+		// Errors about the section don't mention the file yet.
+		err = fmt.Errorf("%s: %w", zipFile, err)
+	}
+	return f, r, err
+}
+
+func (c *config) computeHash(zipFile string) (string, error) {
+	switch c.hashAlgo {
+	case "h1":
+		hash, err := contenthash.HashZip(zipFile, c.readOptions()...)
+		if err != nil {
+			return "", err
+		}
+		return formatHash(hash, c.hashFormat)
+	case "sha256":
+		if c.hashFormat != "h1" {
+			return "", fmt.Errorf("-hash-format is only supported with -hash-algo=h1")
+		}
+		return contenthash.SHA256File(zipFile, c.readOptions()...)
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %q", c.hashAlgo)
+}
+
+// formatHash re-encodes the digest of an "h1:" hash in the given format.
+func formatHash(hash, format string) (string, error) {
+	if format == "h1" {
+		return hash, nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "h1:"))
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64raw":
+		return base64.RawStdEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("unsupported hash format %q", format)
+}
+
+// parseHash returns the "h1:" form of a content hash given in the given
+// format.
+func parseHash(hash, format string) (string, error) {
+	var sum []byte
+	var err error
+	switch format {
+	case "h1":
+		return hash, nil
+	case "hex":
+		sum, err = hex.DecodeString(hash)
+	case "base64raw":
+		sum, err = base64.RawStdEncoding.DecodeString(hash)
+	default:
+		return "", fmt.Errorf("unsupported hash format %q", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %s hash %q: %w", format, hash, err)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(sum), nil
+}
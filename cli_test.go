@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+)
+
+// captureLogs makes the default logger write messages of all levels to the
+// returned buffer for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(newPlainHandler(&buf, slog.LevelDebug)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestPrintSummaryCollisions(t *testing.T) {
+	cf := contenthash.CheckedFiles{
+		Valid: []string{"a/k.go", "a/s.go"},
+		Invalid: []contenthash.FileError{
+			{Path: "a/K.go", Err: &contenthash.CollisionError{Other: "a/k.go", Path: "a/K.go", Folded: "a/k.go"}},
+			{Path: "a/ſ.go", Err: &contenthash.CollisionError{Other: "a/s.go", Path: "a/ſ.go", Folded: "a/s.go"}},
+		},
+	}
+	for _, tt := range []struct {
+		name    string
+		verbose bool
+		want    []string
+	}{
+		{name: "quiet"},
+		{
+			name:    "verbose",
+			verbose: true,
+			want: []string{
+				`"a/k.go" and "a/K.go" both fold to "a/k.go"`,
+				`"a/s.go" and "a/ſ.go" both fold to "a/s.go"`,
+				"2 valid, 0 omitted, 2 invalid files",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			c := &config{verbose: tt.verbose}
+			c.printSummary(cf)
+			for _, want := range tt.want {
+				if !strings.Contains(logs.String(), want+"\n") {
+					t.Errorf("output doesn't contain %q:\n%s", want, logs)
+				}
+			}
+			if len(tt.want) == 0 && logs.Len() != 0 {
+				t.Errorf("got output without -v:\n%s", logs)
+			}
+		})
+	}
+}
+
+// writeTestZip writes a zip file that maps each file name to its contents to
+// dir and returns its path.
+func writeTestZip(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(dir, "test.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = prev }()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestHashAllFailures(t *testing.T) {
+	dir := t.TempDir()
+	good := writeTestZip(t, dir, map[string]string{"example.com/m@v1.0.0/go.mod": "module example.com/m\n"})
+	const goodHash = "h1:yJwNngL0tCKlmRg8yireic46hRGohEbhwD/WSE0Ax3I="
+	badGzip := filepath.Join(dir, "bad.zip.gz")
+	if err := os.WriteFile(badGzip, []byte("\x1f\x8bnot gzip"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.zip")
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "all good", args: []string{good, good}, want: goodHash + "\t" + good + "\n" + goodHash + "\t" + good + "\n"},
+		{name: "first missing", args: []string{missing, good}, want: goodHash + "\t" + good + "\n", wantErr: "failed to hash 1 of 2 zip files"},
+		{name: "first not gzip", args: []string{badGzip, good}, want: goodHash + "\t" + good + "\n", wantErr: "failed to hash 1 of 2 zip files"},
+		{name: "last missing", args: []string{good, missing}, want: goodHash + "\t" + good + "\n", wantErr: "failed to hash 1 of 2 zip files"},
+		{name: "all missing", args: []string{missing, badGzip}, wantErr: "failed to hash 2 of 2 zip files"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			got := captureStdout(t, func() {
+				err = run(context.Background(), append([]string{"hash-all", "-j", "1"}, tt.args...), nil)
+			})
+			if got != tt.want {
+				t.Errorf("got output %q, want %q", got, tt.want)
+			}
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
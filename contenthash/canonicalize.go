@@ -0,0 +1,68 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Canonicalize writes a canonical version of the module zip file zipFile to w.
+// The entries are sorted by name, compressed with deflate and carry no
+// timestamps, comments or extra fields, so that zip files with the same
+// contents result in the same bytes. Executable bits are preserved.
+//
+// Canonicalize checks all restrictions listed in the package documentation
+// before writing anything. Since the names and contents of the files are
+// unchanged, the result has the same content hash as zipFile.
+func Canonicalize(w io.Writer, zipFile string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() {
+		if err != nil {
+			err = &zipError{verb: "canonicalize", path: zipFile, err: err}
+		}
+	}()
+
+	f, z, err := checkedZip(zipFile, o, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Directory entries are kept since they contribute to the content hash.
+	files := append([]*zip.File(nil), z.File...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	zw := zip.NewWriter(w)
+	x := &extractor{o: o}
+	for _, zf := range files {
+		fh := &zip.FileHeader{
+			Name:   zf.Name,
+			Method: zip.Deflate,
+		}
+		isDir := strings.HasSuffix(zf.Name, "/")
+		switch {
+		case isDir:
+			fh.Method = zip.Store
+			fh.SetMode(os.ModeDir | 0755)
+		case zf.Mode()&0111 != 0:
+			fh.SetMode(0755)
+		default:
+			fh.SetMode(0644)
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if isDir {
+			continue
+		}
+		if _, err := x.copyFile(context.Background(), fw, zf); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,164 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression methods that are not supported by archive/zip, but can be enabled
+// with WithExtraCompression. The IDs are those assigned by the zip
+// specification (APPNOTE.TXT, section 4.4.5).
+const (
+	Zstd uint16 = 93
+	XZ   uint16 = 95
+)
+
+// methodNames maps the IDs of the supported compression methods to their
+// names.
+var methodNames = map[uint16]string{
+	zip.Store:   "store",
+	zip.Deflate: "deflate",
+	Zstd:        "zstd",
+	XZ:          "xz",
+}
+
+// ParseMethod returns the ID of the compression method with the given name,
+// which is one of "store", "deflate", "zstd" and "xz", or the decimal ID of
+// any method.
+func ParseMethod(s string) (uint16, error) {
+	for id, name := range methodNames {
+		if s == name {
+			return id, nil
+		}
+	}
+	id, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid compression method %q", s)
+	}
+	return uint16(id), nil
+}
+
+// methodName returns the name of the compression method with the given ID, or
+// the ID itself if it has no name.
+func methodName(method uint16) string {
+	if name, ok := methodNames[method]; ok {
+		return name
+	}
+	return strconv.Itoa(int(method))
+}
+
+// checkAllowedMethod returns an error if allowed is non-empty and doesn't
+// contain method.
+func checkAllowedMethod(method uint16, allowed []uint16) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, m := range allowed {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("compression method %s is not allowed", methodName(method))
+}
+
+// checkMethod returns an error if files compressed with method can't be read.
+func checkMethod(method uint16, extra bool) error {
+	switch method {
+	case zip.Store, zip.Deflate:
+		return nil
+	case Zstd:
+		if extra {
+			return nil
+		}
+		return errors.New("zstd compression is not enabled")
+	case XZ:
+		if extra {
+			return nil
+		}
+		return errors.New("xz compression is not enabled")
+	}
+	return fmt.Errorf("unsupported compression method %d", method)
+}
+
+// registerDecompressors makes z decompress files compressed with zstd and xz.
+func registerDecompressors(z *zip.Reader) {
+	z.RegisterDecompressor(Zstd, func(r io.Reader) io.ReadCloser {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return errReadCloser{err}
+		}
+		return d.IOReadCloser()
+	})
+	z.RegisterDecompressor(XZ, func(r io.Reader) io.ReadCloser {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return errReadCloser{err}
+		}
+		return io.NopCloser(xr)
+	})
+}
+
+// openFile is like zf.Open, but converts panics of the decompressor, which
+// malformed compressed data may trigger, into a FileError for zf. This keeps
+// a long-running process alive when it handles untrusted zip files.
+func openFile(zf *zip.File) (rc io.ReadCloser, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			rc, err = nil, decompressorPanic(zf, v)
+		}
+	}()
+	r, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &recoverReader{r: r, zf: zf}, nil
+}
+
+// recoverReader is an io.ReadCloser that converts panics of the decompressor
+// of zf into errors.
+type recoverReader struct {
+	r  io.ReadCloser
+	zf *zip.File
+}
+
+func (r *recoverReader) Read(p []byte) (n int, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			n, err = 0, decompressorPanic(r.zf, v)
+		}
+	}()
+	return r.r.Read(p)
+}
+
+func (r *recoverReader) Close() (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = decompressorPanic(r.zf, v)
+		}
+	}()
+	return r.r.Close()
+}
+
+func decompressorPanic(zf *zip.File, v any) error {
+	return FileError{Path: zf.Name, Err: fmt.Errorf("decompressor panicked: %v", v)}
+}
+
+// errReadCloser is returned by a decompressor that can't read the header of
+// the compressed data.
+type errReadCloser struct {
+	err error
+}
+
+func (r errReadCloser) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func (r errReadCloser) Close() error {
+	return nil
+}
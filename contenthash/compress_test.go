@@ -0,0 +1,74 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// panicReader is a decompressor that panics in the given phase.
+type panicReader struct {
+	phase string
+}
+
+func (r panicReader) Read(p []byte) (int, error) {
+	if r.phase == "read" {
+		panic("corrupt stream")
+	}
+	return 0, io.EOF
+}
+
+func (r panicReader) Close() error {
+	if r.phase == "close" {
+		panic("corrupt stream")
+	}
+	return nil
+}
+
+func TestOpenFilePanics(t *testing.T) {
+	for _, phase := range []string{"open", "read", "close"} {
+		t.Run(phase, func(t *testing.T) {
+			z := readZip(t, []testFile{{"a.go", ""}})
+			z.RegisterDecompressor(zip.Deflate, func(io.Reader) io.ReadCloser {
+				if phase == "open" {
+					panic("corrupt stream")
+				}
+				return panicReader{phase}
+			})
+			r, err := openFile(z.File[0])
+			if err == nil {
+				_, err = io.ReadAll(r)
+				if closeErr := r.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			var fe FileError
+			if !errors.As(err, &fe) || fe.Path != "a.go" || fe.Err.Error() != "decompressor panicked: corrupt stream" {
+				t.Errorf("got error %v, want FileError for a.go", err)
+			}
+		})
+	}
+}
+
+func TestHashCorruptDeflate(t *testing.T) {
+	b := zipBytes(t, []testFile{{"a.go", strings.Repeat("package a\n", 100)}})
+	// Overwrite the start of the deflate stream of the only file, which
+	// follows its 30-byte local header and name, with an invalid block type.
+	b[30+len("a.go")] = 0x07
+	z, _, err := CheckZip(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("CheckZip: %v", err)
+	}
+	if _, err := HashFiles(z); err == nil {
+		t.Error("HashFiles succeeded on a corrupt deflate stream")
+	}
+	z.RegisterDecompressor(zip.Deflate, func(io.Reader) io.ReadCloser {
+		return panicReader{"read"}
+	})
+	if _, err := HashFiles(z); err == nil || !strings.Contains(err.Error(), "decompressor panicked") {
+		t.Errorf("got error %v from HashFiles, want decompressor panic", err)
+	}
+}
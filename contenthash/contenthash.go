@@ -0,0 +1,195 @@
+// Package contenthash computes the content hash of Go module zip files and
+// extracts them after verifying that they satisfy the restrictions the go
+// command places on module zips.
+//
+// The content hash is the "h1:" hash recorded in go.sum files. It only depends
+// on the names and contents of the files in the zip, not on the way they are
+// compressed or ordered.
+//
+// A zip file is considered valid if it satisfies the following restrictions:
+//
+//   - The zip file itself, as well as the total uncompressed size of its
+//     files, must not exceed MaxZipFile bytes unless configured otherwise
+//     with WithMaxSize.
+//   - The zip file must not have more than MaxZipEntries entries unless
+//     configured otherwise with WithMaxEntries.
+//   - File paths must not be longer than MaxPathLength bytes unless configured
+//     otherwise with WithMaxPathLength.
+//   - File paths must be relative, slash-separated and clean, i.e., not
+//     contain "." or ".." elements or repeated slashes, and must be valid
+//     according to module.CheckFilePath. Absolute paths, backslashes, volume
+//     names such as "C:", invalid UTF-8 and byte order marks are rejected
+//     explicitly.
+//   - Entries must be regular files or directories, not symlinks, devices,
+//     named pipes, sockets or irregular files such as hardlinks.
+//     Directory entries must be stored uncompressed and have size zero.
+//   - Files must be stored or compressed with deflate, or with zstd or xz if
+//     enabled with WithExtraCompression.
+//   - No two file paths may be equal under Unicode case-folding unless
+//     WithCaseSensitive is given, and no path
+//     may refer to both a file and a directory. Each file and directory may
+//     only have a single entry.
+//   - The zip must contain at least one file unless WithAllowEmpty is given.
+//   - If requested with WithCanonical, all files must be contained in a single
+//     module@version directory with a valid module path and version.
+//   - If requested with WithRequireGoMod, the zip must contain a go.mod file
+//     directly below the module@version directory that contains all files,
+//     which declares the module path of that directory.
+package contenthash
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// HashZip returns the "h1:" content hash of the module zip file at path. Of
+// the options, only WithExtraCompression, WithMmap, WithNormalizeSlashes and
+// WithSection have an effect.
+func HashZip(path string, opts ...Option) (string, error) {
+	f, r, err := openSection(path, newOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return HashZipReader(r, r.Size(), opts...)
+}
+
+// Verify returns an error if the "h1:" content hash of the module zip file at
+// path differs from expectedHash, in which case it is a *HashMismatchError
+// that matches ErrHashMismatch. Unlike Unzip with WithExpectedHash, it doesn't
+// check the restrictions on the zip file or extract anything. Of the options,
+// only WithExtraCompression, WithMmap, WithNormalizeSlashes and WithSection
+// have an effect.
+func Verify(path, expectedHash string, opts ...Option) error {
+	hash, err := HashZip(path, opts...)
+	if err != nil {
+		return err
+	}
+	if hash != expectedHash {
+		return &HashMismatchError{Got: hash, Want: expectedHash}
+	}
+	return nil
+}
+
+// HashDir returns the "h1:" content hash of the files below dir, named as if
+// dir were the directory prefix in a zip file, e.g., a module@version
+// directory. If prefix is empty, the files are named relative to dir. For a
+// directory that a zip file was extracted to, this is the content hash of the
+// zip file if prefix is the prefix that was stripped during extraction and no
+// files were skipped. Directories themselves don't contribute to the hash.
+func HashDir(dir, prefix string) (string, error) {
+	return dirhash.HashDir(dir, prefix, dirhash.Hash1)
+}
+
+// HashZipReader returns the "h1:" content hash of the module zip file read from
+// r, which has the given size in bytes. It allows hashing a zip file that is
+// held in memory, e.g., via a bytes.Reader. Of the options, only
+// WithExtraCompression and WithNormalizeSlashes have an effect.
+func HashZipReader(r io.ReaderAt, size int64, opts ...Option) (string, error) {
+	z, err := newZipReader(r, size, newOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	return HashFiles(z)
+}
+
+// openZip opens the zip file at path without checking it. The caller must
+// close c.
+func openZip(path string, opts []Option) (c io.Closer, z *zip.Reader, err error) {
+	o := newOptions(opts)
+	f, r, err := openSection(path, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	z, err = newZipReader(r, r.Size(), o)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, z, nil
+}
+
+// HashFiles returns the "h1:" content hash of the files in z. Together with
+// CheckZip, it allows checking and hashing a zip file while reading its
+// central directory only once.
+func HashFiles(z *zip.Reader) (string, error) {
+	files, open := zipFiles(z)
+	return dirhash.Hash1(files, open)
+}
+
+// FileHashes returns the lines that are hashed to compute the "h1:" content
+// hash of the module zip file at path. Each line has the form
+// "<hex SHA-256 of file>  <name>" and the lines are sorted by name, as in
+// dirhash.Hash1. Of the options, only WithExtraCompression, WithMmap,
+// WithNormalizeSlashes and WithSection have an effect.
+func FileHashes(path string, opts ...Option) ([]string, error) {
+	f, z, err := openZip(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, open := zipFiles(z)
+	return hashLines(files, open)
+}
+
+// zipFiles returns the names of the files in z and a function that opens them,
+// as expected by dirhash.Hash.
+func zipFiles(z *zip.Reader) ([]string, func(string) (io.ReadCloser, error)) {
+	files := make([]string, 0, len(z.File))
+	zfiles := make(map[string]*zip.File, len(z.File))
+	for _, zf := range z.File {
+		files = append(files, zf.Name)
+		zfiles[zf.Name] = zf
+	}
+	return files, func(name string) (io.ReadCloser, error) {
+		return openFile(zfiles[name])
+	}
+}
+
+// hashLines computes the per-file lines hashed by dirhash.Hash1.
+func hashLines(files []string, open func(string) (io.ReadCloser, error)) ([]string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	lines := make([]string, 0, len(files))
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return nil, errors.New("dirhash: filenames with newlines are not supported")
+		}
+		r, err := open(file)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s", h.Sum(nil), file))
+	}
+	return lines, nil
+}
+
+// SHA256File returns the hex-encoded SHA-256 digest of the raw bytes of the
+// file at path. Unlike the content hash, it depends on the exact way the zip
+// was created. Of the options, only WithMmap and WithSection have an effect.
+func SHA256File(path string, opts ...Option) (string, error) {
+	f, r, err := openSection(path, newOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBenchZip writes a zip file with n files of size bytes each, compressed
+// with method, and returns its path. Half of the bytes of each file are
+// random, so deflate roughly halves their size.
+func writeBenchZip(b *testing.B, n, size int, method uint16) string {
+	b.Helper()
+	p := filepath.Join(b.TempDir(), "bench.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	for i := 0; i < n; i++ {
+		rnd.Read(data[:size/2])
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("example.com/m@v1.0.0/f%d", i), Method: method})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// BenchmarkVerifyAndUnzip compares verifying the hash of a zip file before
+// extracting it in a separate pass with passing the hash to Unzip, which
+// computes it from the zip reader it also checks and extracts from. WithDryRun
+// keeps disk writes from dominating the difference in reads.
+func BenchmarkVerifyAndUnzip(b *testing.B) {
+	const n, size = 64, 1 << 20
+	zipFile := writeBenchZip(b, n, size, zip.Deflate)
+	hash, err := HashZip(zipFile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	dir := filepath.Join(b.TempDir(), "out")
+	b.Run("separate", func(b *testing.B) {
+		b.SetBytes(n * size)
+		for i := 0; i < b.N; i++ {
+			if err := Verify(zipFile, hash); err != nil {
+				b.Fatal(err)
+			}
+			if err := Unzip(dir, zipFile, "", WithDryRun()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("single", func(b *testing.B) {
+		b.SetBytes(n * size)
+		for i := 0; i < b.N; i++ {
+			if err := Unzip(dir, zipFile, "", WithDryRun(), WithExpectedHash(hash)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkHashZip(b *testing.B) {
+	const n, size = 64, 1 << 20
+	zipFile := writeBenchZip(b, n, size, zip.Deflate)
+	b.SetBytes(n * size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := HashZip(zipFile); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package contenthash
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Change describes how a file differs between two zip files.
+type Change int
+
+const (
+	// Added means that the file only exists in the second zip file.
+	Added Change = iota
+	// Removed means that the file only exists in the first zip file.
+	Removed
+	// Modified means that the file has different contents in the two zip
+	// files.
+	Modified
+)
+
+func (c Change) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("Change(%d)", int(c))
+}
+
+// A FileDiff is a file that differs between two zip files.
+type FileDiff struct {
+	Name   string
+	Change Change
+}
+
+// Diff compares the names and contents of the files in the module zip files
+// at pathA and pathB, which is what their content hashes depend on. It returns
+// the files that differ, sorted by name, and no differences if and only if the
+// zip files have the same content hash. Of the options, only
+// WithExtraCompression, WithMmap, WithNormalizeSlashes and WithSection have an
+// effect. The filtering options, such as WithInclude and WithExclude, are
+// ignored since the content hash covers all files.
+func Diff(pathA, pathB string, opts ...Option) ([]FileDiff, error) {
+	a, err := fileHashMap(pathA, opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fileHashMap(pathB, opts)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []FileDiff
+	for name, hashA := range a {
+		hashB, ok := b[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, FileDiff{Name: name, Change: Removed})
+		case hashA != hashB:
+			diffs = append(diffs, FileDiff{Name: name, Change: Modified})
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			diffs = append(diffs, FileDiff{Name: name, Change: Added})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs, nil
+}
+
+// fileHashMap maps the name of each file in the zip file at path to the hex
+// SHA-256 of its contents.
+func fileHashMap(path string, opts []Option) (map[string]string, error) {
+	lines, err := FileHashes(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(lines))
+	for _, line := range lines {
+		hash, name, _ := strings.Cut(line, "  ")
+		hashes[name] = hash
+	}
+	return hashes, nil
+}
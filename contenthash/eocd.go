@@ -0,0 +1,92 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrCorrupt is matched by errors reporting that a file is not a complete zip
+// archive, e.g., because its download was interrupted.
+var ErrCorrupt = errors.New("zip appears truncated or corrupt")
+
+const (
+	eocdSignature = "PK\x05\x06"
+	eocdLen       = 22
+	// maxCommentLen is the maximum length of the archive comment, which
+	// follows the end of central directory record.
+	maxCommentLen = 1<<16 - 1
+)
+
+// newZipReader is like zip.NewReader, but first checks that the end of
+// central directory record is present and that the central directory it
+// declares lies within the file. This distinguishes truncated downloads from
+// the cryptic errors archive/zip reports for them. The returned reader
+// supports the compression methods and has the names normalized as requested
+// in o.
+func newZipReader(r io.ReaderAt, size int64, o *options) (*zip.Reader, error) {
+	if err := checkEOCD(r, size); err != nil {
+		return nil, err
+	}
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if o.extraCompression {
+		registerDecompressors(z)
+	}
+	if o.normalizeSlashes {
+		normalizeSlashes(z)
+	}
+	return z, nil
+}
+
+// checkEOCD returns an error matching ErrCorrupt if the end of central
+// directory record of the zip file read from r is missing or declares a
+// central directory that doesn't fit before it.
+func checkEOCD(r io.ReaderAt, size int64) error {
+	n := int64(eocdLen + maxCommentLen)
+	if n > size {
+		n = size
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, size-n); err != nil && err != io.EOF {
+		return err
+	}
+	i := findEOCD(buf)
+	if i < 0 {
+		return fmt.Errorf("%w: end of central directory record not found", ErrCorrupt)
+	}
+	eocd := buf[i : i+eocdLen]
+	dirSize := int64(binary.LittleEndian.Uint32(eocd[12:16]))
+	dirOffset := int64(binary.LittleEndian.Uint32(eocd[16:20]))
+	if dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		// The actual values are stored in the zip64 records, which
+		// archive/zip checks.
+		return nil
+	}
+	eocdOffset := size - n + int64(i)
+	if dirOffset+dirSize > eocdOffset {
+		return fmt.Errorf("%w: central directory at offset %d with size %d extends beyond offset %d of the end of central directory record", ErrCorrupt, dirOffset, dirSize, eocdOffset)
+	}
+	return nil
+}
+
+// findEOCD returns the offset of the end of central directory record in buf,
+// which holds the end of a zip file, or -1 if there is none. It searches
+// backwards and skips candidates whose archive comment doesn't fit into the
+// rest of buf, since the comment itself may contain the signature.
+func findEOCD(buf []byte) int {
+	for i := len(buf) - eocdLen; i >= 0; i-- {
+		if string(buf[i:i+len(eocdSignature)]) != eocdSignature {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(buf[i+20 : i+22]))
+		if i+eocdLen+commentLen <= len(buf) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCheckEOCD(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		comment  string
+		truncate int
+		wantErr  bool
+	}{
+		{name: "no comment"},
+		{name: "comment", comment: "built by a test"},
+		{name: "comment ending with signature", comment: "trailing " + eocdSignature},
+		{name: "comment with signature", comment: "a " + eocdSignature + " inside"},
+		{name: "truncated", truncate: 10, wantErr: true},
+		{name: "truncated with comment", comment: "x " + eocdSignature, truncate: 30, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.Create("a.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte("package a\n")); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.SetComment(tt.comment); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b := buf.Bytes()[:buf.Len()-tt.truncate]
+			_, _, err = CheckZip(bytes.NewReader(b), int64(len(b)))
+			if tt.wantErr {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Errorf("got error %v, want ErrCorrupt", err)
+				}
+			} else if err != nil {
+				t.Errorf("CheckZip: %v", err)
+			}
+		})
+	}
+}
+
+func TestFindEOCD(t *testing.T) {
+	// record returns an end of central directory record with the given
+	// comment length, followed by the comment.
+	record := func(comment string) string {
+		return eocdSignature + string(make([]byte, 16)) + string([]byte{byte(len(comment)), byte(len(comment) >> 8)}) + comment
+	}
+	for _, tt := range []struct {
+		name string
+		buf  string
+		want int
+	}{
+		{name: "no comment", buf: "data" + record(""), want: 4},
+		{name: "comment", buf: "data" + record("comment"), want: 4},
+		{name: "signature at end of comment", buf: "data" + record("x"+eocdSignature), want: 4},
+		{name: "signature in comment", buf: "data" + record("x"+eocdSignature+string(make([]byte, 16))+"\xff\xffy"), want: 4},
+		{name: "comment too long", buf: "data" + record("comment")[:eocdLen+3], want: -1},
+		{name: "missing", buf: "data without a record", want: -1},
+		{name: "short", buf: eocdSignature, want: -1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findEOCD([]byte(tt.buf)); got != tt.want {
+				t.Errorf("findEOCD(%q) = %d, want %d", tt.buf, got, tt.want)
+			}
+		})
+	}
+}
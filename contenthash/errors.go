@@ -0,0 +1,48 @@
+package contenthash
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHashMismatch is matched by errors reporting that the hash of a zip file
+// differs from the expected one.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// ErrDirNotEmpty is matched by errors reporting that the target directory of
+// Unzip already contains files. Callers may clear it and try again, or use
+// WithForce.
+var ErrDirNotEmpty = errors.New("target directory exists and is not empty")
+
+// ErrNoPrefixMatch is matched by errors reporting that a prefix passed to
+// Unzip, UnzipFile or UnzipTar doesn't match any file in the zip.
+var ErrNoPrefixMatch = errors.New("no file matched the prefix")
+
+// HashMismatchError reports that the hash of a zip file differs from the
+// expected one. It matches ErrHashMismatch.
+type HashMismatchError struct {
+	Got, Want string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("got hash %s, expected %s", e.Got, e.Want)
+}
+
+func (e *HashMismatchError) Is(target error) bool {
+	return target == ErrHashMismatch
+}
+
+// CollisionError reports that two paths in a zip file are equal under Unicode
+// case-folding.
+type CollisionError struct {
+	// Other is the path that appears first in the zip file and Path is the
+	// one that collides with it.
+	Other, Path string
+	// Folded is the case-folded form shared by both paths, which helps to
+	// understand collisions caused by surprising folding rules.
+	Folded string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("case-insensitive file name collision: %q and %q", e.Other, e.Path)
+}
@@ -0,0 +1,107 @@
+package contenthash
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// fileFilter selects the files to extract by their paths after stripping the
+// prefix.
+type fileFilter struct {
+	// include is nil if all files are included. Otherwise, it maps each
+	// included path to whether a file with that path has been seen.
+	include      map[string]bool
+	exclude      []string
+	metadataOnly bool
+}
+
+func newFileFilter(o *options) (*fileFilter, error) {
+	f := &fileFilter{exclude: o.exclude, metadataOnly: o.metadataOnly}
+	if o.include != nil {
+		f.include = make(map[string]bool, len(o.include))
+		for _, p := range o.include {
+			f.include[p] = false
+		}
+	}
+	for _, p := range o.exclude {
+		if _, err := matchPattern(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+	}
+	return f, nil
+}
+
+// match reports whether the file with the given path should be extracted.
+// Exclusion takes precedence over inclusion.
+func (f *fileFilter) match(name string) bool {
+	if f.metadataOnly && name != "go.mod" && name != "go.sum" {
+		return false
+	}
+	if f.include != nil {
+		if _, ok := f.include[name]; !ok {
+			return false
+		}
+		f.include[name] = true
+	}
+	return !f.excluded(name)
+}
+
+// excluded reports whether name or one of its parent directories matches an
+// exclude pattern.
+func (f *fileFilter) excluded(name string) bool {
+	for _, p := range f.exclude {
+		for dir := name; dir != "."; dir = path.Dir(dir) {
+			if ok, _ := matchPattern(p, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// err returns an error listing the included paths that didn't match any file.
+func (f *fileFilter) err() error {
+	var missing []string
+	for p, seen := range f.include {
+		if !seen {
+			missing = append(missing, fmt.Sprintf("%q", p))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("included files not found: %s", strings.Join(missing, ", "))
+}
+
+// matchPattern reports whether name matches pattern. Path elements are matched
+// with path.Match, except that a "**" element matches any number of elements,
+// including none.
+func matchPattern(pattern, name string) (bool, error) {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchElems(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			// Validate the rest of the pattern.
+			_, err := path.Match(strings.Join(pattern, "/"), "")
+			return false, err
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
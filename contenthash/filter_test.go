@@ -0,0 +1,112 @@
+package contenthash
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"testdata", "testdata", true},
+		{"testdata", "a/testdata", false},
+		{"*.go", "a.go", true},
+		{"*.go", "a/b.go", false},
+		{"**/testdata", "testdata", true},
+		{"**/testdata", "a/b/testdata", true},
+		{"**/testdata/*", "a/testdata/x.txt", true},
+		{"**/testdata/*", "a/testdata/x/y.txt", false},
+		{"**/testdata/*", "a/testdata", false},
+		{"vendor/**", "vendor/a/b.go", true},
+		{"vendor/**", "a/vendor/b.go", false},
+	} {
+		got, err := matchPattern(tt.pattern, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, %v, want %v", tt.pattern, tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := matchPattern("[", "a"); err == nil {
+		t.Error("matchPattern accepted an invalid pattern")
+	}
+}
+
+func TestUnzipExclude(t *testing.T) {
+	files := []testFile{
+		{"example.com/m@v1.0.0/go.mod", "module example.com/m\n"},
+		{"example.com/m@v1.0.0/m.go", "package m\n"},
+		{"example.com/m@v1.0.0/testdata/a.txt", "a"},
+		{"example.com/m@v1.0.0/sub/sub.go", "package sub\n"},
+		{"example.com/m@v1.0.0/sub/testdata/b.txt", "b"},
+		{"example.com/m@v1.0.0/sub/testdata/deep/c.txt", "c"},
+	}
+	for _, tt := range []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name:    "testdata contents",
+			exclude: []string{"**/testdata/*"},
+			want:    []string{"go.mod", "m.go", "sub/sub.go"},
+		},
+		{
+			name:    "nested testdata directory",
+			exclude: []string{"sub/testdata"},
+			want:    []string{"go.mod", "m.go", "sub/sub.go", "testdata/a.txt"},
+		},
+		{
+			name:    "repeated",
+			exclude: []string{"*.go", "sub"},
+			want:    []string{"go.mod", "testdata/a.txt"},
+		},
+		{
+			name:    "exclude wins over include",
+			include: []string{"go.mod", "m.go"},
+			exclude: []string{"m.go"},
+			want:    []string{"go.mod"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "out")
+			opts := []Option{WithExclude(tt.exclude)}
+			if tt.include != nil {
+				opts = append(opts, WithInclude(tt.include))
+			}
+			if err := Unzip(dir, writeZip(t, files), AutoPrefix, opts...); err != nil {
+				t.Fatal(err)
+			}
+			// Empty directories are listed with a trailing slash to catch
+			// directories created for excluded files.
+			var got []string
+			err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || p == dir {
+					return err
+				}
+				rel, err := filepath.Rel(dir, p)
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if entries, err := os.ReadDir(p); err != nil || len(entries) == 0 {
+						got = append(got, filepath.ToSlash(rel)+"/")
+					}
+					return nil
+				}
+				got = append(got, filepath.ToSlash(rel))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
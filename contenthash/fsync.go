@@ -0,0 +1,34 @@
+package contenthash
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// syncDir flushes the directory entries of dir to stable storage, which makes
+// files created in or renamed into dir durable.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories can't be opened for syncing on Windows, where metadata
+		// updates are journaled by NTFS.
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncTree calls syncDir for dir and all directories below it.
+func syncTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return syncDir(path)
+	})
+}
@@ -0,0 +1,37 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkUnzipFsync measures the overhead of WithFsync, which mostly depends
+// on the number of files and the file system.
+func BenchmarkUnzipFsync(b *testing.B) {
+	const n, size = 256, 16 << 10
+	zipFile := writeBenchZip(b, n, size, zip.Deflate)
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "nosync"},
+		{name: "fsync", opts: []Option{WithFsync()}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			dir := filepath.Join(b.TempDir(), "out")
+			b.SetBytes(n * size)
+			for i := 0; i < b.N; i++ {
+				if err := Unzip(dir, zipFile, AutoPrefix, bb.opts...); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				if err := os.RemoveAll(dir); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
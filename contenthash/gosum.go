@@ -0,0 +1,61 @@
+package contenthash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// ErrNoSumLine is matched by errors reporting that a go.sum file contains no
+// line for the zip of a module.
+var ErrNoSumLine = errors.New("no go.sum line found")
+
+// ParseSumLine parses a go.sum line of the form "<module> <version> h1:<hash>"
+// for a module zip and returns its components.
+func ParseSumLine(line string) (mod module.Version, hash string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return module.Version{}, "", fmt.Errorf("malformed go.sum line %q: expected 3 fields, got %d", line, len(fields))
+	}
+	mod = module.Version{Path: fields[0], Version: fields[1]}
+	hash = fields[2]
+	if strings.HasSuffix(mod.Version, "/go.mod") {
+		return module.Version{}, "", fmt.Errorf("go.sum line %q is for a go.mod file, not a module zip", line)
+	}
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return module.Version{}, "", fmt.Errorf("malformed go.sum line %q: %w", line, err)
+	}
+	if !strings.HasPrefix(hash, "h1:") {
+		return module.Version{}, "", fmt.Errorf("malformed go.sum line %q: unsupported hash %q", line, hash)
+	}
+	return mod, hash, nil
+}
+
+// LookupSum returns the "h1:" hash of the zip of mod recorded in the go.sum
+// file at path. If there is no such line, the error matches ErrNoSumLine.
+func LookupSum(path string, mod module.Version) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 3 && fields[0] == mod.Path && fields[1] == mod.Version {
+			_, hash, err := ParseSumLine(s.Text())
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", path, err)
+			}
+			return hash, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w for %s in %s", ErrNoSumLine, mod, path)
+}
@@ -0,0 +1,58 @@
+package contenthash
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// mkdirAll is like os.MkdirAll, but sets the permissions of all directories it
+// creates to perm regardless of the umask.
+func mkdirAll(dir string, perm fs.FileMode) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, perm); err != nil {
+		// Another goroutine may have created the directory concurrently.
+		if errors.Is(err, fs.ErrExist) {
+			if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+				return nil
+			}
+		}
+		return err
+	}
+	return os.Chmod(dir, perm)
+}
+
+// checkWritable returns an error if files can't be created in dir or, if it
+// doesn't exist yet, in its nearest existing ancestor, in which mkdirAll would
+// create the missing directories.
+func checkWritable(dir string) error {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".writable-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
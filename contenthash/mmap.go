@@ -0,0 +1,60 @@
+package contenthash
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// MmapThreshold is the size in bytes from which WithMmap maps zip files into
+// memory. For smaller files, the cost of setting up the mapping outweighs the
+// saved system calls.
+const MmapThreshold = 64 << 20
+
+// errMmapUnsupported is returned by mmapFile on platforms without support for
+// memory mapping.
+var errMmapUnsupported = errors.New("memory mapping is not supported on this platform")
+
+// mmapReader is an io.ReaderAt backed by a read-only memory mapping of a file.
+type mmapReader struct {
+	data []byte
+}
+
+func (m *mmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("mmap: negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *mmapReader) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
+
+// readerAt returns a reader for the contents of f, which has the given size,
+// and a closer that releases it. With WithMmap, large files are memory mapped
+// and f is closed right away, since the mapping outlives the file descriptor.
+func readerAt(f *os.File, size int64, o *options) (io.ReaderAt, io.Closer) {
+	if !o.mmap || size < MmapThreshold {
+		return f, f
+	}
+	data, err := mmapFile(f, size)
+	if err != nil {
+		return f, f
+	}
+	f.Close()
+	m := &mmapReader{data: data}
+	return m, m
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package contenthash
+
+import "os"
+
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"testing"
+)
+
+// BenchmarkHashZipMmap compares hashing a zip file just above MmapThreshold
+// through regular reads and through a memory mapping. The files are stored
+// uncompressed so that the way the zip is read isn't hidden behind
+// decompression.
+func BenchmarkHashZipMmap(b *testing.B) {
+	const n, size = 65, 1 << 20
+	zipFile := writeBenchZip(b, n, size, zip.Store)
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "read"},
+		{name: "mmap", opts: []Option{WithMmap()}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.SetBytes(n * size)
+			for i := 0; i < b.N; i++ {
+				if _, err := HashZip(zipFile, bb.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
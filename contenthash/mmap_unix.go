@@ -0,0 +1,21 @@
+//go:build unix
+
+package contenthash
+
+import (
+	"math"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of f into memory read-only.
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	if size > math.MaxInt {
+		return nil, errMmapUnsupported
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
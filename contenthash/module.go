@@ -0,0 +1,177 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// modulePrefix returns the module@version prefix shared by all of the given
+// files, i.e., the leading path elements up to and including the first element
+// that contains an "@".
+func modulePrefix(files []string) (string, error) {
+	prefixes := make(map[string]bool)
+	for _, name := range files {
+		prefix, ok := fileModulePrefix(name)
+		if !ok {
+			return "", fmt.Errorf("file %s is not contained in a module@version directory", name)
+		}
+		prefixes[prefix] = true
+	}
+	switch len(prefixes) {
+	case 0:
+		return "", fmt.Errorf("zip contains no files")
+	case 1:
+		for prefix := range prefixes {
+			return prefix, nil
+		}
+	}
+	sorted := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		sorted = append(sorted, prefix)
+	}
+	sort.Strings(sorted)
+	return "", fmt.Errorf("files are contained in multiple module@version directories: %s", strings.Join(sorted, ", "))
+}
+
+// fileModulePrefix returns the leading path elements of name up to and
+// including the first directory that contains an "@".
+func fileModulePrefix(name string) (string, bool) {
+	i := strings.Index(name, "@")
+	if i < 0 {
+		return "", false
+	}
+	j := strings.Index(name[i:], "/")
+	if j < 0 {
+		return "", false
+	}
+	return name[:i+j], true
+}
+
+// fileNames returns the names of the files in z, skipping directory entries.
+func fileNames(z *zip.Reader) []string {
+	var files []string
+	for _, zf := range z.File {
+		if !strings.HasSuffix(zf.Name, "/") {
+			files = append(files, zf.Name)
+		}
+	}
+	return files
+}
+
+// ModulePath returns the module path of the module@version directory that
+// contains all files in z, e.g., to look it up in GONOSUMDB-style patterns. It
+// returns an error if there is no such directory.
+func ModulePath(z *zip.Reader) (string, error) {
+	prefix, err := modulePrefix(fileNames(z))
+	if err != nil {
+		return "", err
+	}
+	return prefix[:strings.LastIndex(prefix, "@")], nil
+}
+
+// checkGoMod returns an error if files doesn't contain a go.mod file at the
+// root of the module prefix or if the module path declared in the go.mod file
+// in z doesn't match the prefix.
+func checkGoMod(z *zip.Reader, files []string) error {
+	prefix, err := modulePrefix(files)
+	if err != nil {
+		return fmt.Errorf("cannot locate go.mod: %w", err)
+	}
+	goMod := prefix + "/go.mod"
+	for _, zf := range z.File {
+		if zf.Name == goMod {
+			return checkGoModPath(zf, prefix)
+		}
+	}
+	return fmt.Errorf("missing go.mod in module prefix %s", prefix)
+}
+
+// checkGoModPath returns an error if the module path declared in the go.mod
+// file zf doesn't match the module@version prefix.
+func checkGoModPath(zf *zip.File, prefix string) error {
+	r, err := openFile(zf)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, int64(zf.UncompressedSize64)))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", zf.Name, err)
+	}
+	modPath := modfile.ModulePath(data)
+	if modPath == "" {
+		return fmt.Errorf("%s has no module directive", zf.Name)
+	}
+	i := strings.LastIndex(prefix, "@")
+	prefixPath, version := prefix[:i], prefix[i+1:]
+	if modPath != prefixPath {
+		return fmt.Errorf("%s declares module path %s, but the zip prefix is %s", zf.Name, modPath, prefix)
+	}
+	// The major version suffix of the path, if any, must match the version,
+	// e.g., example.com/m/v2 requires a v2.x.y version.
+	_, pathMajor, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return fmt.Errorf("%s declares invalid module path %s", zf.Name, modPath)
+	}
+	if err := module.CheckPathMajor(version, pathMajor); err != nil {
+		return fmt.Errorf("%s declares module path %s: %w", zf.Name, modPath, err)
+	}
+	return nil
+}
+
+// checkCanonical returns an error if files are not all contained in a single
+// module@version directory with a valid module path and version.
+func checkCanonical(files []string) error {
+	prefix, err := modulePrefix(files)
+	if err != nil {
+		return fmt.Errorf("zip is not canonical: %w", err)
+	}
+	i := strings.LastIndex(prefix, "@")
+	if err := module.Check(prefix[:i], prefix[i+1:]); err != nil {
+		return fmt.Errorf("zip is not canonical: %w", err)
+	}
+	return nil
+}
+
+// checkModule returns an error if files are not all contained in the
+// module@version directory of mod or if mod is not valid.
+func checkModule(files []string, mod module.Version) error {
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return fmt.Errorf("invalid expected module: %w", err)
+	}
+	prefix, err := modulePrefix(files)
+	if err != nil {
+		return fmt.Errorf("zip is not a zip of %s: %w", mod, err)
+	}
+	if prefix != mod.String() {
+		return fmt.Errorf("zip contains module %s, expected %s", prefix, mod)
+	}
+	return nil
+}
+
+// moduleCacheDir returns the module@version prefix shared by all of the given
+// files and the slash-separated path of the corresponding directory relative to
+// the root of the module cache, in which upper-case letters in the module path
+// and version are escaped.
+func moduleCacheDir(files []string) (prefix, dir string, err error) {
+	prefix, err = modulePrefix(files)
+	if err != nil {
+		return "", "", err
+	}
+	i := strings.LastIndex(prefix, "@")
+	escPath, err := module.EscapePath(prefix[:i])
+	if err != nil {
+		return "", "", err
+	}
+	escVersion, err := module.EscapeVersion(prefix[i+1:])
+	if err != nil {
+		return "", "", err
+	}
+	return prefix, escPath + "@" + escVersion, nil
+}
@@ -0,0 +1,181 @@
+package contenthash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveDir moves the directory src to dst, which must either not exist or be
+// an empty directory. If dst is an existing directory, which may be a mount
+// point that can't be removed, the entries of src are moved into it instead.
+// If src and dst are on different file systems, the contents of src are copied
+// to dst and src is removed afterwards. If fsync is true, the copies are
+// flushed to stable storage.
+func moveDir(src, dst string, fsync bool) error {
+	info, err := os.Lstat(dst)
+	switch {
+	case err == nil && info.IsDir():
+		return moveContents(src, dst, fsync)
+	case err == nil:
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	return move(src, dst, fsync)
+}
+
+// replaceDir moves the directory src to dst like moveDir, but dst may exist
+// and have contents. These are moved aside first and only removed once src is
+// in place, or restored if src can't be moved.
+func replaceDir(src, dst string, fsync bool) error {
+	if _, err := os.Lstat(dst); errors.Is(err, fs.ErrNotExist) {
+		return moveDir(src, dst, fsync)
+	} else if err != nil {
+		return err
+	}
+	backup, err := makeTempDir(filepath.Dir(dst), "."+filepath.Base(dst)+".old-", "")
+	if err != nil {
+		return err
+	}
+	old := filepath.Join(backup, "old")
+	var restore func() error
+	if err := os.Rename(dst, old); err == nil {
+		restore = func() error { return os.Rename(old, dst) }
+	} else {
+		// dst may be a mount point, which can't be renamed, so move its
+		// entries instead.
+		if err := os.Mkdir(old, 0700); err != nil {
+			os.RemoveAll(backup)
+			return err
+		}
+		if err := moveEntries(dst, old, false); err != nil {
+			os.RemoveAll(backup)
+			return err
+		}
+		restore = func() error { return moveEntries(old, dst, false) }
+	}
+	if err := moveDir(src, dst, fsync); err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			return fmt.Errorf("%w; the previous contents of %s are left in %s: %v", err, dst, old, restoreErr)
+		}
+		os.RemoveAll(backup)
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// moveContents moves the entries of the directory src into the existing empty
+// directory dst and removes src. If an entry can't be moved, the entries moved
+// so far are moved back to src.
+func moveContents(src, dst string, fsync bool) error {
+	if err := moveEntries(src, dst, fsync); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// moveEntries moves the entries of the directory src into the directory dst.
+// If an entry can't be moved, the entries moved so far are moved back to src.
+func moveEntries(src, dst string, fsync bool) (err error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	var moved []string
+	defer func() {
+		if err != nil {
+			for _, name := range moved {
+				move(filepath.Join(dst, name), filepath.Join(src, name), false)
+			}
+		}
+	}()
+	for _, e := range entries {
+		if err := move(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), fsync); err != nil {
+			return err
+		}
+		moved = append(moved, e.Name())
+	}
+	if fsync {
+		return syncDir(dst)
+	}
+	return nil
+}
+
+// move renames the file or directory src to dst, which must not exist. If src
+// and dst are on different file systems, src is copied to dst and removed
+// afterwards.
+func move(src, dst string, fsync bool) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyDir(src, dst, fsync); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	if fsync {
+		if err := syncTree(dst); err != nil {
+			os.RemoveAll(dst)
+			return err
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+// copyDir recursively copies the regular files and directories in src, or src
+// itself if it is a regular file, to dst, preserving their permissions and the modification times of files.
+func copyDir(src, dst string, fsync bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			// The mode passed to Mkdir is subject to the umask.
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		if err := copyFile(path, target, info.Mode().Perm(), fsync); err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode, fsync bool) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err == nil && fsync {
+		err = w.Sync()
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,58 @@
+package contenthash
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceDir(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		noSrc   bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "replaced", want: []string{"new.go"}},
+		{name: "restored", noSrc: true, want: []string{"old.go", "sub"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			src, dst := filepath.Join(root, ".out.tmp"), filepath.Join(root, "out")
+			if !tt.noSrc {
+				if err := os.Mkdir(src, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(src, "new.go"), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.MkdirAll(filepath.Join(dst, "sub"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dst, "old.go"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := replaceDir(src, dst, false); (err != nil) != tt.wantErr {
+				t.Fatalf("replaceDir: %v, want error %v", err, tt.wantErr)
+			}
+			entries, err := os.ReadDir(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v in %s, want %v", got, dst, tt.want)
+			}
+			// Neither src nor the backup of the old contents is left behind.
+			if entries, _ := os.ReadDir(root); len(entries) != 1 {
+				t.Errorf("got %d entries in %s, want only %s", len(entries), root, dst)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/mod/module"
+)
+
+// sanitizeName returns an error if name, the path of a zip entry without a
+// trailing slash, could refer to a location outside of the directory it is
+// extracted to or is otherwise not a valid module file path.
+//
+// All file paths are validated with sanitizeName, both when checking and when
+// extracting a zip file.
+func sanitizeName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("empty file path")
+	case !utf8.ValidString(name):
+		return fmt.Errorf("file path is not valid UTF-8: %q", name)
+	case strings.ContainsRune(name, '\uFEFF'):
+		return fmt.Errorf("file path contains a byte order mark: %q", name)
+	case strings.HasPrefix(name, "/"):
+		return fmt.Errorf("file path is absolute: %s", name)
+	case strings.Contains(name, `\`):
+		return fmt.Errorf("file path contains backslash: %s", name)
+	case filepath.VolumeName(name) != "" || len(name) >= 2 && name[1] == ':':
+		return fmt.Errorf("file path has a volume name: %s", name)
+	case strings.HasSuffix(name, "/"):
+		return fmt.Errorf("file path has a trailing slash: %s", name)
+	case name == ".":
+		return errors.New("file path refers to the root directory")
+	case escapesRoot(name):
+		return fmt.Errorf("file path escapes the root directory: %s", name)
+	case path.Clean(name) != name:
+		return uncleanPathError(name)
+	}
+	return module.CheckFilePath(name)
+}
+
+// escapesRoot reports whether the relative path name refers to a location
+// outside of the directory it is resolved against, even after cleaning.
+func escapesRoot(name string) bool {
+	clean := path.Clean(name)
+	return clean == ".." || strings.HasPrefix(clean, "../")
+}
+
+// uncleanPathError explains why name, which differs from its clean form but
+// doesn't escape the root, is rejected.
+func uncleanPathError(name string) error {
+	for _, elem := range strings.Split(name, "/") {
+		switch elem {
+		case "":
+			return fmt.Errorf("file path is not clean, it has an empty path element: %s", name)
+		case ".", "..":
+			return fmt.Errorf("file path is not clean, it has a %q path element: %s", elem, name)
+		}
+	}
+	return fmt.Errorf("file path is not clean: %s", name)
+}
+
+// normalizeSlashes replaces backslashes in the names of the entries of z by
+// forward slashes.
+func normalizeSlashes(z *zip.Reader) {
+	for _, zf := range z.File {
+		zf.Name = strings.ReplaceAll(zf.Name, `\`, "/")
+	}
+}
+
+// safeJoin joins dir and the slash-separated path name after verifying that
+// the result is contained in dir.
+func safeJoin(dir, name string) (string, error) {
+	if err := sanitizeName(name); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, dst)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file path escapes target directory: %s", name)
+	}
+	return dst, nil
+}
@@ -0,0 +1,188 @@
+package contenthash
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "a/b.go"},
+		{name: "example.com/m@v1.0.0/go.mod"},
+		{name: "", wantErr: "empty file path"},
+		{name: "../../etc/passwd", wantErr: "escapes the root directory"},
+		{name: "..", wantErr: "escapes the root directory"},
+		{name: "a/../../b", wantErr: "escapes the root directory"},
+		{name: "a/../b", wantErr: `has a ".." path element`},
+		{name: "a/./b", wantErr: `has a "." path element`},
+		{name: "a//b", wantErr: "empty path element"},
+		{name: ".", wantErr: "refers to the root directory"},
+		{name: "/abs/path", wantErr: "file path is absolute"},
+		{name: `C:\win\path`, wantErr: "contains backslash"},
+		{name: "C:/win/path", wantErr: "has a volume name"},
+		{name: `..\..\etc\passwd`, wantErr: "contains backslash"},
+		{name: `a\b.go`, wantErr: "contains backslash"},
+		{name: "\uFEFFgo.mod", wantErr: "contains a byte order mark"},
+		{name: "a/\uFEFFb.go", wantErr: "contains a byte order mark"},
+		{name: "a\xffb.go", wantErr: "not valid UTF-8"},
+		{name: "\xef\xbb", wantErr: "not valid UTF-8"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sanitizeName(tt.name)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("sanitizeName(%q) = %v", tt.name, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("sanitizeName(%q) = %v, want error containing %q", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{name: "a/b.go", want: filepath.Join(dir, "a", "b.go"), ok: true},
+		{name: "../escape"},
+		{name: "a/../../escape"},
+		{name: "/abs/path"},
+		{name: `..\escape`},
+		{name: `C:\win\path`},
+	} {
+		got, err := safeJoin(dir, tt.name)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("safeJoin(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		} else if !tt.ok && err == nil {
+			t.Errorf("safeJoin(%q) = %q, want error", tt.name, got)
+		}
+	}
+}
+
+func TestUnzipTraversal(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "../../etc/passwd", want: "escapes the root directory"},
+		{name: "/abs/path", want: "file path is absolute"},
+		{name: `C:\win\path`, want: "contains backslash"},
+		{name: `..\..\etc\passwd`, opts: []Option{WithNormalizeSlashes()}, want: "escapes the root directory"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			zipFile := writeZip(t, []testFile{{"a.go", "package a\n"}, {tt.name, "data"}})
+			err := Unzip(filepath.Join(root, "out"), zipFile, "", tt.opts...)
+			if err == nil {
+				t.Fatal("Unzip succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q, want error containing %q", err, tt.want)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(root, "*")); len(matches) != 0 {
+				t.Errorf("Unzip created %v", matches)
+			}
+		})
+	}
+}
+
+func TestNormalizeSlashes(t *testing.T) {
+	files := []testFile{{`m\go.mod`, "module m\n"}, {`m\sub\a.go`, "package sub\n"}, {"m/b.go", "package m\n"}}
+	for _, tt := range []struct {
+		name      string
+		normalize bool
+		wantValid []string
+		wantErr   string
+	}{
+		{name: "rejected", wantErr: `file path contains backslash: m\go.mod`},
+		{name: "normalized", normalize: true, wantValid: []string{"m/go.mod", "m/sub/a.go", "m/b.go"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.normalize {
+				opts = append(opts, WithNormalizeSlashes())
+			}
+			cf, err := checkZip(t, files, opts...)
+			if tt.wantErr != "" {
+				if len(cf.Invalid) != 2 || cf.Invalid[0].Err.Error() != tt.wantErr {
+					t.Errorf("got invalid files %v, want %s and another", cf.Invalid, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckZip: %v", err)
+			}
+			if !reflect.DeepEqual(cf.Valid, tt.wantValid) {
+				t.Errorf("got valid files %v, want %v", cf.Valid, tt.wantValid)
+			}
+
+			dir := filepath.Join(t.TempDir(), "out")
+			if err := Unzip(dir, writeZip(t, files), "m", opts...); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"go.mod", "sub/a.go", "b.go"} {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestHashNormalizeSlashes(t *testing.T) {
+	slashes, err := HashZip(writeZip(t, []testFile{{"m/a.go", "package m\n"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backslashes, err := HashZip(writeZip(t, []testFile{{`m\a.go`, "package m\n"}}), WithNormalizeSlashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slashes != backslashes {
+		t.Errorf("got hash %s with normalized backslashes, want %s", backslashes, slashes)
+	}
+}
+
+func TestSanitizeNameUnclean(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "..", wantErr: "file path escapes the root directory: .."},
+		{name: "../a", wantErr: "file path escapes the root directory: ../a"},
+		{name: "a/../..", wantErr: "file path escapes the root directory: a/../.."},
+		{name: "a/b/../../../c", wantErr: "file path escapes the root directory: a/b/../../../c"},
+		{name: "a/../b", wantErr: `file path is not clean, it has a ".." path element: a/../b`},
+		{name: "a/..", wantErr: `file path is not clean, it has a ".." path element: a/..`},
+		{name: ".", wantErr: "file path refers to the root directory"},
+		{name: "./a", wantErr: `file path is not clean, it has a "." path element: ./a`},
+		{name: "a/.", wantErr: `file path is not clean, it has a "." path element: a/.`},
+		{name: "a/", wantErr: "file path has a trailing slash: a/"},
+		{name: "a//", wantErr: "file path has a trailing slash: a//"},
+		{name: "a//b", wantErr: "file path is not clean, it has an empty path element: a//b"},
+		{name: "a/.b"},
+		{name: "a/..b"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sanitizeName(tt.name)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("sanitizeName(%q) = %v", tt.name, err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("sanitizeName(%q) = %v, want %s", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
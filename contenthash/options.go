@@ -0,0 +1,600 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"golang.org/x/mod/module"
+)
+
+// An Option configures how CheckZip and Unzip process a zip file.
+type Option func(*options)
+
+type options struct {
+	maxSize    int64
+	maxEntries int
+	maxPathLen int
+	maxRatio   float64
+	modePolicy ModePolicy
+	// uniformMode is the mode of all extracted files if uniform is set.
+	uniformMode os.FileMode
+	uniform     bool
+	dirMode     os.FileMode
+	onExtract   func(ExtractedFile)
+	onProgress  func(written, total int64)
+	onStats     func(Stats)
+	onWarning   func(string)
+	onHash      func(string)
+	validators  []Validator
+	force       bool
+	fsync       bool
+	jobs        int
+	rateLimit   int64
+	dryRun      bool
+
+	// skipIfExtracted makes Unzip succeed without extracting if the target
+	// directory isn't empty.
+	skipIfExtracted bool
+	continueOnError bool
+	stripComponents int
+	rewrites        []rewrite
+	cacheLayout     bool
+	hashSubdir      bool
+	include         []string
+	exclude         []string
+	metadataOnly    bool
+	preserveMtime   bool
+	sha256          bool
+	tempSuffix      string
+	strict          bool
+	expectedHash    string
+
+	allowEmpty       bool
+	caseSensitive    bool
+	escapeCollisions bool
+	requireGoMod     bool
+	canonical        bool
+	expectedModule   module.Version
+	extraCompression bool
+	allowedMethods   []uint16
+	normalizeSlashes bool
+
+	sectionOffset int64
+	sectionLength int64
+	mmap          bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		maxSize:    MaxZipFile,
+		maxEntries: MaxZipEntries,
+		maxPathLen: MaxPathLength,
+		dirMode:    0755,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMaxSize sets the maximum size in bytes of both the zip file and the total
+// uncompressed size of its files. The default is MaxZipFile, which matches the
+// limit enforced by the go command.
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithMaxEntries sets the maximum number of entries, including directories, in
+// the zip file. The default is MaxZipEntries. If n is zero or negative, the
+// number of entries is not limited.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxPathLength sets the maximum length in bytes of the path of an entry,
+// which CheckZip reports for longer paths in CheckedFiles.Invalid. The default
+// is MaxPathLength. On Windows, Unzip additionally rejects files whose path
+// below the target directory exceeds MAX_PATH, which many tools can't handle.
+// If n is zero or negative, the length of paths is not limited.
+func WithMaxPathLength(n int) Option {
+	return func(o *options) {
+		o.maxPathLen = n
+	}
+}
+
+// WithMaxRatio makes CheckZip reject files whose uncompressed size is more than
+// r times their compressed size, as reported by CompressionRatio. This catches
+// zip bombs made of files that individually stay below the size limits. If r
+// is zero or negative, which is the default, the ratio is not limited.
+func WithMaxRatio(r float64) Option {
+	return func(o *options) {
+		o.maxRatio = r
+	}
+}
+
+// WithAllowEmpty makes CheckZip accept zip files that contain no files, only
+// directories or no entries at all. By default, they are rejected since they
+// usually result from a truncated download or a misconfigured build.
+func WithAllowEmpty() Option {
+	return func(o *options) {
+		o.allowEmpty = true
+	}
+}
+
+// WithRequireGoMod makes CheckZip require a go.mod file directly below the
+// module@version directory that contains all files in the zip. The module path
+// declared in the go.mod file must match the module@version directory,
+// including a major version suffix that matches the version.
+func WithRequireGoMod() Option {
+	return func(o *options) {
+		o.requireGoMod = true
+	}
+}
+
+// WithExpectedModule makes CheckZip require that all files are contained in the
+// module@version directory of mod, whose module path and version must be valid
+// according to module.Check, e.g., if the identity of the module is known
+// independently of the zip.
+func WithExpectedModule(mod module.Version) Option {
+	return func(o *options) {
+		o.expectedModule = mod
+	}
+}
+
+// WithCanonical makes CheckZip require that all files are contained in a
+// single module@version directory whose module path and version are valid
+// according to module.Check.
+func WithCanonical() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// A Validator implements custom rules for the files in a zip file. It is
+// called with the name, uncompressed size and mode of each file that satisfies
+// the restrictions listed in the package documentation and returns an error if
+// the file violates a rule.
+type Validator func(name string, size int64, mode fs.FileMode) error
+
+// WithValidator makes CheckZip call v for each file and report the files for
+// which it returns an error in CheckedFiles.Invalid. It may be given multiple
+// times, in which case the validators are called in order until one returns
+// an error.
+func WithValidator(v Validator) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, v)
+	}
+}
+
+// WithCaseSensitive makes CheckZip accept paths that are only equal under
+// Unicode case-folding, such as README and readme, which the go command
+// rejects. Exact duplicates and paths that are both a file and a directory are
+// still rejected. Such zip files are not portable since extracting them fails
+// on case-insensitive file systems, which is reported to WithOnWarning.
+func WithCaseSensitive() Option {
+	return func(o *options) {
+		o.caseSensitive = true
+	}
+}
+
+// WithEscapeCollisions makes CheckZip accept paths that are only equal under
+// Unicode case-folding, like WithCaseSensitive, and makes Unzip extract them
+// without conflicts on case-insensitive file systems by appending "~" and a
+// short hash of the name in the zip to all but the first of them. The path
+// each file was extracted to is reported in ExtractedFile.Path.
+func WithEscapeCollisions() Option {
+	return func(o *options) {
+		o.escapeCollisions = true
+	}
+}
+
+// WithExtraCompression makes CheckZip, Unzip and the hash functions accept files
+// compressed with zstd or xz in addition to the store and deflate methods
+// supported by the go command.
+func WithExtraCompression() Option {
+	return func(o *options) {
+		o.extraCompression = true
+	}
+}
+
+// WithAllowedMethods makes CheckZip reject entries compressed with a method
+// other than the given ones, e.g., to only allow zip.Store for compliance
+// reasons. This only restricts the methods that are accepted otherwise: zstd
+// and xz additionally require WithExtraCompression.
+func WithAllowedMethods(methods ...uint16) Option {
+	return func(o *options) {
+		o.allowedMethods = methods
+	}
+}
+
+// WithNormalizeSlashes makes the functions that read a zip file replace
+// backslashes in the names of its entries by forward slashes before checking,
+// hashing or extracting them, which accepts zips created by Windows tools that
+// violate the zip specification. The content hash is then computed over the
+// normalized names. Without it, names containing backslashes are rejected.
+func WithNormalizeSlashes() Option {
+	return func(o *options) {
+		o.normalizeSlashes = true
+	}
+}
+
+// WithSection makes the functions that take the path of a zip file read the
+// zip from the length bytes starting at offset in that file instead of from
+// the whole file, e.g., if zip files are concatenated in a larger pack file.
+// If length is zero, the zip extends to the end of the file. It is an error if
+// the section is not within the file.
+func WithSection(offset, length int64) Option {
+	return func(o *options) {
+		o.sectionOffset = offset
+		o.sectionLength = length
+	}
+}
+
+// WithMmap makes the functions that take the path of a zip file read zip files
+// of at least MmapThreshold bytes through a read-only memory mapping instead of
+// read system calls, which can be faster for large zips. Where memory mapping
+// isn't supported or fails, the file is read as usual.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// A ModePolicy determines the permissions of files extracted by Unzip. On
+// Windows, files without write permission get the read-only attribute and
+// execute permissions are ignored, which is reported to WithOnWarning.
+type ModePolicy int
+
+const (
+	// ModeReadOnly makes extracted files read-only, like the go command does
+	// for files in the module cache. Files are executable if any execute bit
+	// is set in the zip. This is the default.
+	ModeReadOnly ModePolicy = iota
+	// ModeWritable makes extracted files writable by their owner. Files are
+	// executable if any execute bit is set in the zip.
+	ModeWritable
+	// ModePreserve applies the permission bits stored in the zip verbatim.
+	ModePreserve
+)
+
+// ParseModePolicy returns the ModePolicy with the given name, which is one of
+// "readonly", "writable" and "preserve".
+func ParseModePolicy(s string) (ModePolicy, error) {
+	switch s {
+	case "readonly":
+		return ModeReadOnly, nil
+	case "writable":
+		return ModeWritable, nil
+	case "preserve":
+		return ModePreserve, nil
+	}
+	return 0, fmt.Errorf("invalid mode policy %q", s)
+}
+
+func (p ModePolicy) String() string {
+	switch p {
+	case ModeReadOnly:
+		return "readonly"
+	case ModeWritable:
+		return "writable"
+	case ModePreserve:
+		return "preserve"
+	}
+	return fmt.Sprintf("ModePolicy(%d)", int(p))
+}
+
+// WithModePolicy sets the policy that determines the permissions of extracted
+// files. The default is ModeReadOnly.
+func WithModePolicy(p ModePolicy) Option {
+	return func(o *options) {
+		o.modePolicy = p
+	}
+}
+
+// WithUniformMode makes Unzip give all extracted files exactly the permissions
+// perm regardless of the modes stored in the zip file and the umask, e.g., for
+// bit-reproducible extraction in hermetic builds. It takes precedence over
+// WithModePolicy.
+func WithUniformMode(perm os.FileMode) Option {
+	return func(o *options) {
+		o.uniformMode = perm
+		o.uniform = true
+	}
+}
+
+// WithDirMode sets the permissions of the directories created by Unzip, which
+// include the target directory, its missing parents and all directories within
+// it. The permissions are applied regardless of the umask. The default is 0755.
+func WithDirMode(perm os.FileMode) Option {
+	return func(o *options) {
+		o.dirMode = perm
+	}
+}
+
+// fileMode returns the permissions of the file extracted from zf.
+func (o *options) fileMode(zf *zip.File) os.FileMode {
+	if o.uniform {
+		return o.uniformMode
+	}
+	perm := zf.Mode().Perm()
+	if o.modePolicy == ModePreserve {
+		return perm
+	}
+	mode := os.FileMode(0444)
+	if o.modePolicy == ModeWritable {
+		mode |= 0200
+	}
+	if perm&0111 != 0 {
+		mode |= 0111
+	}
+	return mode
+}
+
+// WithProgress registers a function that is called by Unzip whenever data has
+// been written to disk. written is the number of bytes written so far and total
+// is the total uncompressed size of the files to be extracted.
+func WithProgress(fn func(written, total int64)) Option {
+	return func(o *options) {
+		o.onProgress = fn
+	}
+}
+
+// WithOnWarning registers a function that is called by CheckZip and Unzip with
+// a message for each condition that doesn't prevent extraction, but may have
+// unexpected effects. Currently, this includes file modes that can't be
+// honored on Windows and paths that only differ in case with
+// WithCaseSensitive.
+func WithOnWarning(fn func(msg string)) Option {
+	return func(o *options) {
+		o.onWarning = fn
+	}
+}
+
+// WithSkipIfExtracted makes Unzip treat a target directory as already
+// extracted if it contains the directory shared by the files that would be
+// extracted, such as the module@version directory if no prefix is stripped, or
+// else all of their top-level files and directories. This allows idempotent
+// cache population. The zip is still checked and its hash verified, but
+// nothing is written and Unzip succeeds, reporting the skip to WithOnWarning.
+// A target directory that isn't empty, but doesn't contain these files is
+// still rejected, unless WithForce is given, in which case it is replaced.
+// With WithCacheLayout or WithHashSubdir, this applies to the directory
+// created below the target directory.
+func WithSkipIfExtracted() Option {
+	return func(o *options) {
+		o.skipIfExtracted = true
+	}
+}
+
+// WithForce makes Unzip replace the contents of the target directory if it
+// isn't empty. The existing contents are only removed after the zip has been
+// checked and extracted successfully. Unzip refuses to replace the root
+// directory or a symlink.
+func WithForce() Option {
+	return func(o *options) {
+		o.force = true
+	}
+}
+
+// WithFsync makes Unzip flush each extracted file and the directories that
+// contain them to stable storage before moving them into place, so that a
+// crash can't leave truncated files behind. This can slow down extracting many
+// small files considerably, depending on the file system.
+func WithFsync() Option {
+	return func(o *options) {
+		o.fsync = true
+	}
+}
+
+// WithContinueOnError makes Unzip attempt to extract all files even if some of
+// them fail, e.g., because they are larger than declared, and return a
+// FileErrorList of all failures. Since the target directory is only populated
+// if all files have been extracted, it is still left untouched on error.
+func WithContinueOnError() Option {
+	return func(o *options) {
+		o.continueOnError = true
+	}
+}
+
+// WithRateLimit limits the rate at which Unzip writes uncompressed data to the
+// given number of bytes per second across all files, e.g., to avoid starving
+// other processes of disk I/O. A rate of 0 means no limit, which is the
+// default.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(o *options) {
+		o.rateLimit = bytesPerSec
+	}
+}
+
+// WithJobs sets the number of files Unzip extracts concurrently. The default
+// is 1. Functions registered with WithOnExtract and WithProgress are never
+// called concurrently.
+func WithJobs(n int) Option {
+	return func(o *options) {
+		o.jobs = n
+	}
+}
+
+// WithDryRun makes Unzip decompress all files and verify their sizes without
+// writing anything to disk. Functions registered with WithOnExtract and
+// WithProgress are still called.
+func WithDryRun() Option {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// WithStripComponents makes Unzip remove the given number of leading path
+// elements from each file after stripping the prefix. Files with fewer path
+// elements are skipped, unless WithStrict is given.
+func WithStripComponents(n int) Option {
+	return func(o *options) {
+		o.stripComponents = n
+	}
+}
+
+// WithRewrite makes Unzip replace the prefix from of the paths of files by to
+// after stripping the prefix and path components, e.g., to extract the files
+// below "src/" to "source/". It may be given multiple times, in which case the
+// first rule whose from is a prefix of a path applies. It is an error if a
+// rewritten path is invalid or equal to that of another file.
+func WithRewrite(from, to string) Option {
+	return func(o *options) {
+		o.rewrites = append(o.rewrites, rewrite{from: from, to: to})
+	}
+}
+
+// WithCacheLayout makes Unzip lay out the files like the go command does in the
+// module cache: the files below the module@version directory shared by all
+// files are extracted to the directory <dir>/<module>@<version>, with
+// upper-case letters escaped as "!" followed by the lower-case letter. That
+// directory must be empty unless WithForce is given, but dir itself need not
+// be, so that dir can be the root of a module cache (GOMODCACHE). Only the
+// extracted source tree is written, not the download cache. The prefix passed
+// to Unzip must be empty.
+func WithCacheLayout() Option {
+	return func(o *options) {
+		o.cacheLayout = true
+	}
+}
+
+// WithHashSubdir makes Unzip extract the files to the subdirectory of the
+// target directory that is named after the "h1:" content hash of the zip file,
+// e.g., for a content-addressed cache. The name is the hash with the ":"
+// replaced by "-" and the digest encoded with the URL-safe base64 alphabet, so
+// that it contains no slashes, e.g., "h1-oAAWzW2VJTP3b07KtvaQ-cvAywFi6K9I2Py6IOe9Y5Y=".
+// The subdirectory must be empty unless WithForce or WithSkipIfExtracted is
+// given, but the target directory itself need not be. With WithCacheLayout,
+// the module@version directory is created below the subdirectory.
+func WithHashSubdir() Option {
+	return func(o *options) {
+		o.hashSubdir = true
+	}
+}
+
+// WithTempSuffix makes Unzip name the temporary directory it extracts to
+// ".<dir>.tmp-<suffix>", where <dir> is the base name of the target directory,
+// instead of using a random suffix, e.g., to make extraction reproducible in
+// tests. Unzip fails if that directory already exists, so concurrent calls for
+// the same target directory must use different suffixes.
+func WithTempSuffix(suffix string) Option {
+	return func(o *options) {
+		o.tempSuffix = suffix
+	}
+}
+
+// WithInclude makes Unzip extract only the files whose paths, after stripping
+// the prefix, are among the given slash-separated paths. The whole zip is still
+// checked. Paths that match no file are ignored, unless WithStrict is given.
+func WithInclude(paths []string) Option {
+	return func(o *options) {
+		o.include = paths
+	}
+}
+
+// WithExclude makes Unzip skip the files whose paths, after stripping the
+// prefix, or whose parent directories match one of the given patterns.
+// Patterns use path.Match syntax for each path element, and a "**" element
+// matches any number of elements, e.g., "**/testdata" skips all testdata
+// directories. Exclusion takes precedence over WithInclude.
+func WithExclude(patterns []string) Option {
+	return func(o *options) {
+		o.exclude = patterns
+	}
+}
+
+// WithMetadataOnly makes Unzip extract only the go.mod and go.sum files whose
+// paths, after stripping the prefix, are at the root, e.g., to analyze the
+// dependencies of a module without materializing its sources. The whole zip is
+// still checked. It is not an error if either file is missing.
+func WithMetadataOnly() Option {
+	return func(o *options) {
+		o.metadataOnly = true
+	}
+}
+
+// WithPreserveMtime makes Unzip set the modification time of extracted files
+// to the one stored in the zip file instead of the time of extraction, and
+// UnzipTar store it instead of the Unix epoch. Files without a valid
+// timestamp are left alone. Since the go command writes the same timestamp
+// for all files in a module zip, this mostly matters for other zip files.
+func WithPreserveMtime() Option {
+	return func(o *options) {
+		o.preserveMtime = true
+	}
+}
+
+// WithStrict reports or rejects conditions that are otherwise ignored:
+//   - Unzip fails on files that have too few path elements to be stripped
+//     according to WithStripComponents.
+//   - Unzip fails if a path passed to WithInclude matches no file.
+//   - CheckZip reports an archive comment and unusually large extra fields of
+//     files in CheckedFiles.Omitted.
+//   - CheckZip fails if the entries aren't sorted byte-wise by path, as in
+//     zips served by the module proxy, which it otherwise only reports in
+//     CheckedFiles.Unsorted.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithExpectedHash makes Unzip and UnzipFile verify that the zip file has the
+// given "h1:" content hash before extracting anything. The hash is computed
+// from the same reader that is used for checking and extracting, so the zip
+// file is only opened once. On mismatch, a *HashMismatchError is returned.
+func WithExpectedHash(hash string) Option {
+	return func(o *options) {
+		o.expectedHash = hash
+	}
+}
+
+// WithOnHash registers a function that is called with the "h1:" content hash
+// of the zip file by the functions that honor WithExpectedHash, before
+// extracting anything. This allows recording the hash of a zip file that is
+// extracted for the first time. If WithExpectedHash is also given, the
+// function is only called if the hash matches.
+func WithOnHash(fn func(hash string)) Option {
+	return func(o *options) {
+		o.onHash = fn
+	}
+}
+
+// ExtractedFile describes a file written by Unzip.
+type ExtractedFile struct {
+	// Name is the name of the file in the zip.
+	Name string
+	// Path is the slash-separated path of the file relative to the target
+	// directory.
+	Path string
+	// Size is the number of bytes written.
+	Size int64
+	// Mode is the mode of the file.
+	Mode os.FileMode
+	// SHA256 is the hex-encoded SHA-256 digest of the contents of the file
+	// if WithSHA256 is given and empty otherwise.
+	SHA256 string
+}
+
+// WithOnExtract registers a function that is called after each file has been
+// written by Unzip.
+func WithOnExtract(fn func(ExtractedFile)) Option {
+	return func(o *options) {
+		o.onExtract = fn
+	}
+}
+
+// WithSHA256 makes Unzip and UnzipTar compute the SHA-256 digest of each file
+// while writing it and report it in ExtractedFile.SHA256, so that the files
+// don't have to be read again for auditing.
+func WithSHA256() Option {
+	return func(o *options) {
+		o.sha256 = true
+	}
+}
@@ -0,0 +1,156 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AutoPrefix is a prefix that Unzip, UnzipFile and UnzipTar replace with the
+// module@version directory that contains all files in the zip or, if the zip
+// isn't a module zip, with the single top-level directory shared by all files.
+// It is an error if there is no such directory.
+const AutoPrefix = "@auto"
+
+// prefixMatcher selects the files to extract based on a comma-separated list
+// of path prefixes. Each prefix may contain path.Match patterns, e.g., a
+// trailing "/*", which are matched against the leading path elements of a
+// file.
+type prefixMatcher struct {
+	prefixes []string
+	matched  []bool
+	// auto is set if the prefix is AutoPrefix and hasn't been resolved yet.
+	auto bool
+}
+
+func newPrefixMatcher(prefix string) (*prefixMatcher, error) {
+	m := &prefixMatcher{}
+	if prefix == "" {
+		return m, nil
+	}
+	if prefix == AutoPrefix {
+		m.auto = true
+		return m, nil
+	}
+	for _, p := range strings.Split(prefix, ",") {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" {
+			return nil, fmt.Errorf("empty prefix in %q", prefix)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid prefix %q: %w", p, err)
+		}
+		m.prefixes = append(m.prefixes, p)
+	}
+	m.matched = make([]bool, len(m.prefixes))
+	return m, nil
+}
+
+// resolve replaces AutoPrefix with the prefix detected from the files in z.
+func (m *prefixMatcher) resolve(z *zip.Reader) error {
+	if !m.auto {
+		return nil
+	}
+	files := fileNames(z)
+	prefix, err := modulePrefix(files)
+	if err != nil {
+		prefix, err = topLevelDir(files)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot detect prefix: %w", err)
+	}
+	m.prefixes = []string{escapePattern(prefix)}
+	m.matched = []bool{false}
+	m.auto = false
+	return nil
+}
+
+// topLevelDir returns the top-level directory that contains all of the given
+// files.
+func topLevelDir(files []string) (string, error) {
+	var dir string
+	for _, name := range files {
+		i := strings.Index(name, "/")
+		if i < 0 {
+			return "", fmt.Errorf("file %s is not contained in a directory", name)
+		}
+		if dir == "" {
+			dir = name[:i]
+		} else if name[:i] != dir {
+			return "", fmt.Errorf("files are contained in multiple top-level directories: %s, %s", dir, name[:i])
+		}
+	}
+	if dir == "" {
+		return "", errors.New("zip contains no files")
+	}
+	return dir, nil
+}
+
+// escapePattern escapes the characters in name that have a special meaning
+// in path.Match patterns.
+func escapePattern(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// strip returns name with the first matching prefix removed. ok is false if
+// no prefix matches name. If there are no prefixes, name is returned as is.
+func (m *prefixMatcher) strip(name string) (rest string, ok bool) {
+	if len(m.prefixes) == 0 {
+		return name, true
+	}
+	for i, p := range m.prefixes {
+		n := strings.Count(p, "/") + 1
+		elems := strings.SplitN(name, "/", n+1)
+		if len(elems) <= n {
+			continue
+		}
+		if ok, _ := path.Match(p, strings.Join(elems[:n], "/")); ok {
+			m.matched[i] = true
+			return elems[n], true
+		}
+	}
+	return "", false
+}
+
+// err returns an error listing the prefixes that didn't match any file.
+func (m *prefixMatcher) err() error {
+	var unmatched []string
+	for i, p := range m.prefixes {
+		if !m.matched[i] {
+			unmatched = append(unmatched, fmt.Sprintf("%q", p))
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNoPrefixMatch, strings.Join(unmatched, ", "))
+}
+
+// CommonPrefix returns the longest directory that contains all valid files,
+// e.g., the module@version directory of a module zip file, which can be
+// passed as the prefix to Unzip. It returns the empty string if the files
+// don't share a common directory.
+func (cf CheckedFiles) CommonPrefix() string {
+	if len(cf.Valid) == 0 {
+		return ""
+	}
+	prefix := path.Dir(cf.Valid[0])
+	for _, name := range cf.Valid[1:] {
+		for prefix != "." && !strings.HasPrefix(name, prefix+"/") {
+			prefix = path.Dir(prefix)
+		}
+	}
+	if prefix == "." {
+		return ""
+	}
+	return prefix
+}
@@ -0,0 +1,47 @@
+package contenthash
+
+import (
+	"io"
+	"sync"
+)
+
+// progress reports the number of bytes written by Unzip to the function
+// registered with WithProgress. A nil *progress reports nothing.
+type progress struct {
+	fn    func(written, total int64)
+	total int64
+
+	mu      *sync.Mutex // guards written and calls to fn
+	written int64
+}
+
+// writer returns a writer that writes to w and reports the bytes written.
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &progressWriter{w: w, p: p}
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.mu.Lock()
+	pw.p.written += int64(n)
+	pw.p.fn(pw.p.written, pw.p.total)
+	pw.p.mu.Unlock()
+	return n, err
+}
+
+// totalSize returns the total uncompressed size of files.
+func totalSize(files []extractedFile) int64 {
+	var total int64
+	for _, f := range files {
+		total += int64(f.zf.UncompressedSize64)
+	}
+	return total
+}
@@ -0,0 +1,82 @@
+package contenthash
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that limits the rate at which the goroutines
+// of an extraction read uncompressed data. Its capacity is one second worth of
+// bytes. A nil *rateLimiter doesn't limit anything.
+type rateLimiter struct {
+	rate int64 // bytes per second
+
+	mu     sync.Mutex // guards tokens and last
+	tokens int64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait takes n tokens from the bucket, blocking until they are available or
+// ctx is done. Tokens are reserved before waiting, so concurrent callers are
+// served in order.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += int64(now.Sub(l.last).Seconds() * float64(l.rate))
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= int64(n)
+	missing := -l.tokens
+	l.mu.Unlock()
+	if missing <= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(float64(missing) / float64(l.rate) * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader returns a reader that reads from r at the limited rate.
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Don't read more than fits into the bucket at once so that the rate
+	// stays smooth.
+	if int64(len(p)) > lr.l.rate {
+		p = p[:lr.l.rate]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.l.wait(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package contenthash
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// rewrite is a prefix substitution registered with WithRewrite.
+type rewrite struct {
+	from, to string
+}
+
+// rewriteName applies the first rule in rewrites whose from is a prefix of
+// name and returns the resulting path, which is validated like the names of
+// zip entries.
+func rewriteName(name string, rewrites []rewrite) (string, error) {
+	for _, r := range rewrites {
+		if !strings.HasPrefix(name, r.from) {
+			continue
+		}
+		rewritten := r.to + strings.TrimPrefix(name, r.from)
+		if err := sanitizeName(rewritten); err != nil {
+			return "", fmt.Errorf("rewriting %s with %s:%s: %w", name, r.from, r.to, err)
+		}
+		return rewritten, nil
+	}
+	return name, nil
+}
+
+// checkRewritten returns an error if rewriting made the paths of two files
+// equal or made the path of a file the parent directory of another.
+func checkRewritten(files []extractedFile) error {
+	names := make(map[string]string, len(files))
+	for _, f := range files {
+		if other, ok := names[f.name]; ok {
+			return fmt.Errorf("files %s and %s are both rewritten to %s", other, f.zf.Name, f.name)
+		}
+		names[f.name] = f.zf.Name
+	}
+	for _, f := range files {
+		for dir := path.Dir(f.name); dir != "."; dir = path.Dir(dir) {
+			if other, ok := names[dir]; ok {
+				return fmt.Errorf("file %s is rewritten to %s, which is a parent directory of %s", other, dir, f.zf.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package contenthash
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteName(t *testing.T) {
+	// The more specific rule comes first, so it wins over the overlapping
+	// src/ rule.
+	rules := []rewrite{
+		{from: "src/internal/", to: "private/"},
+		{from: "src/", to: "source/"},
+		{from: "src", to: "other"},
+		{from: "doc/", to: ""},
+		{from: "bad/", to: "../"},
+	}
+	for _, tt := range []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "go.mod", want: "go.mod"},
+		{name: "src/a.go", want: "source/a.go"},
+		{name: "src/internal/b.go", want: "private/b.go"},
+		{name: "src/internalx/c.go", want: "source/internalx/c.go"},
+		{name: "srcx/d.go", want: "otherx/d.go"},
+		{name: "doc/README", want: "README"},
+		{name: "bad/e.go", wantErr: "rewriting bad/e.go with bad/:../: file path escapes the root directory: ../e.go"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rewriteName(tt.name, rules)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("rewriteName(%q) = %q, %v, want error %q", tt.name, got, err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("rewriteName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnzipRewrite(t *testing.T) {
+	files := []testFile{
+		{"m@v1/go.mod", "module m\n"},
+		{"m@v1/src/a.go", "package a\n"},
+		{"m@v1/src/gen/b.go", "package gen\n"},
+	}
+	for _, tt := range []struct {
+		name    string
+		rules   [][2]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "first match wins",
+			rules: [][2]string{{"src/gen/", "generated/"}, {"src/", "source/"}},
+			want:  []string{"go.mod", "source/a.go", "generated/b.go"},
+		},
+		{
+			name:  "overlapping rule shadowed",
+			rules: [][2]string{{"src/", "source/"}, {"src/gen/", "generated/"}},
+			want:  []string{"go.mod", "source/a.go", "source/gen/b.go"},
+		},
+		{
+			name:    "escapes",
+			rules:   [][2]string{{"src/", "../"}},
+			wantErr: "file path escapes the root directory",
+		},
+		{
+			name:    "same path",
+			rules:   [][2]string{{"src/gen/b.go", "src/a.go"}},
+			wantErr: "files m@v1/src/a.go and m@v1/src/gen/b.go are both rewritten to src/a.go",
+		},
+		{
+			name:    "file becomes parent directory",
+			rules:   [][2]string{{"go.mod", "src"}},
+			wantErr: "file m@v1/go.mod is rewritten to src, which is a parent directory of m@v1/src/a.go",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "out")
+			var opts []Option
+			for _, r := range tt.rules {
+				opts = append(opts, WithRewrite(r[0], r[1]))
+			}
+			err := Unzip(dir, writeZip(t, files), "m@v1", opts...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.want {
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package contenthash
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Open opens the zip file at path for reading, e.g., with CheckZip or
+// HashZipReader, and returns a reader for the part of it that holds the zip
+// file. Of the options, only WithSection and WithMmap have an effect. The
+// caller must close c once done with r.
+func Open(path string, opts ...Option) (c io.Closer, r *io.SectionReader, err error) {
+	return openSection(path, newOptions(opts))
+}
+
+// openSection opens the file at path and returns a reader for the part of it
+// that holds the zip file, which is the whole file unless WithSection is used.
+// The caller must close c.
+func openSection(path string, o *options) (c io.Closer, r *io.SectionReader, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	offset, length, err := sectionBounds(o.sectionOffset, o.sectionLength, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	ra, c := readerAt(f, info.Size(), o)
+	return c, io.NewSectionReader(ra, offset, length), nil
+}
+
+// sectionBounds validates the section of a file of the given size that starts
+// at offset and has the given length, where a zero length extends it to the
+// end of the file. It returns the offset and the actual length.
+func sectionBounds(offset, length, size int64) (int64, int64, error) {
+	if offset < 0 || length < 0 {
+		return 0, 0, fmt.Errorf("invalid section at offset %d with length %d", offset, length)
+	}
+	if offset > size {
+		return 0, 0, fmt.Errorf("offset %d is beyond the end of the file of size %d", offset, size)
+	}
+	if length == 0 {
+		return offset, size - offset, nil
+	}
+	if length > size-offset {
+		return 0, 0, fmt.Errorf("section [%d, %d) extends beyond the end of the file of size %d", offset, offset+length, size)
+	}
+	return offset, length, nil
+}
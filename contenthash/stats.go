@@ -0,0 +1,68 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"strings"
+	"time"
+)
+
+// Stats describes the work done by Unzip.
+type Stats struct {
+	// Entries is the number of entries in the zip file.
+	Entries int
+	// Bytes is the total uncompressed size of the files in the zip file.
+	Bytes int64
+	// Written is the number of bytes extracted, which is less than Bytes if
+	// files are skipped because of the prefix or filters.
+	Written int64
+	// Check is the time spent reading and checking the zip file.
+	Check time.Duration
+	// Hash is the time spent computing the content hash, which is zero unless
+	// it is required by WithExpectedHash, WithOnHash or WithHashSubdir.
+	Hash time.Duration
+	// Extract is the time spent extracting the files.
+	Extract time.Duration
+	// Skipped is true if nothing was extracted because the target directory
+	// wasn't empty and WithSkipIfExtracted was given.
+	Skipped bool
+}
+
+// WithStats registers a function that is called with statistics about the zip
+// file and the time spent in each phase when Unzip succeeds. Durations are only
+// measured if this option is given.
+func WithStats(fn func(Stats)) Option {
+	return func(o *options) {
+		o.onStats = fn
+	}
+}
+
+// stopwatch measures wall-clock durations if it is enabled and does nothing
+// otherwise.
+type stopwatch struct {
+	enabled bool
+	start   time.Time
+}
+
+func (s *stopwatch) reset() {
+	if s.enabled {
+		s.start = time.Now()
+	}
+}
+
+func (s *stopwatch) elapsed() time.Duration {
+	if !s.enabled {
+		return 0
+	}
+	return time.Since(s.start)
+}
+
+// zipStats returns the number of entries in z and the total uncompressed size
+// of its files.
+func zipStats(z *zip.Reader) (entries int, bytes int64) {
+	for _, zf := range z.File {
+		if !strings.HasSuffix(zf.Name, "/") {
+			bytes += int64(zf.UncompressedSize64)
+		}
+	}
+	return len(z.File), bytes
+}
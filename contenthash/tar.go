@@ -0,0 +1,99 @@
+package contenthash
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path"
+	"time"
+)
+
+// UnzipTar writes the files of the module zip file zipFile to w as a tar
+// archive instead of extracting them to a directory. The files are selected and
+// named as by Unzip, and their modes are determined by WithModePolicy. Parent
+// directories are added with the permissions set by WithDirMode. All entries
+// have the Unix epoch as their modification time, so that the output only
+// depends on the contents of the zip.
+//
+// UnzipTar checks all restrictions listed in the package documentation before
+// writing anything and enforces the declared sizes of the files. If an error is
+// returned, w may have received a partial archive.
+func UnzipTar(ctx context.Context, w io.Writer, zipFile, prefix string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() {
+		if err != nil {
+			err = &zipError{verb: "unzip", path: zipFile, err: err}
+		}
+	}()
+
+	prefixes, err := newPrefixMatcher(prefix)
+	if err != nil {
+		return err
+	}
+	f, z, err := checkedZip(zipFile, o, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	files, err := selectFiles(z, prefixes, o)
+	if err != nil {
+		return err
+	}
+
+	x := &extractor{o: o, limiter: newRateLimiter(o.rateLimit)}
+	if o.onProgress != nil {
+		x.progress = &progress{fn: o.onProgress, total: totalSize(files), mu: &x.mu}
+	}
+	tw := tar.NewWriter(w)
+	epoch := time.Unix(0, 0)
+	dirs := make(map[string]bool)
+	var addDir func(dir string) error
+	addDir = func(dir string) error {
+		if dir == "." || dirs[dir] {
+			return nil
+		}
+		if err := addDir(path.Dir(dir)); err != nil {
+			return err
+		}
+		dirs[dir] = true
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     int64(o.dirMode.Perm()),
+			ModTime:  epoch,
+			Format:   tar.FormatPAX,
+		})
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sanitizeName(f.name); err != nil {
+			return err
+		}
+		if err := addDir(path.Dir(f.name)); err != nil {
+			return err
+		}
+		mode := o.fileMode(f.zf)
+		mtime, ok := o.mtime(f.zf)
+		if !ok {
+			mtime = epoch
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     int64(mode),
+			Size:     int64(f.zf.UncompressedSize64),
+			ModTime:  mtime,
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return err
+		}
+		n, sum, err := x.copyAndHash(ctx, tw, f.zf)
+		if err != nil {
+			return err
+		}
+		x.extracted(ExtractedFile{Name: f.zf.Name, Path: f.name, Size: n, Mode: mode, SHA256: sum})
+	}
+	return tw.Close()
+}
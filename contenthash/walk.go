@@ -0,0 +1,80 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// WalkZip checks that the module zip file at zipPath satisfies all
+// restrictions listed in the package documentation and, if requested with
+// WithExpectedHash, has the expected hash. It then calls fn for each file in
+// the order of the central directory with its name, a reader for its
+// uncompressed contents and its FileInfo. This allows processing the contents
+// without extracting them. Directory entries are skipped.
+//
+// The reader is only valid during the call to fn and returns an error if the
+// contents are larger or smaller than the size declared in the zip file. If fn
+// returns an error, WalkZip stops and returns it.
+func WalkZip(zipPath string, fn func(name string, r io.Reader, info fs.FileInfo) error, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() {
+		if err != nil {
+			err = &zipError{verb: "walk", path: zipPath, err: err}
+		}
+	}()
+
+	f, z, err := checkedZip(zipPath, o, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, zf := range z.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		if err := walkFile(zf, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkFile(zf *zip.File, fn func(name string, r io.Reader, info fs.FileInfo) error) error {
+	r, err := openFile(zf)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sr := &sizeReader{
+		r:    &io.LimitedReader{R: r, N: int64(zf.UncompressedSize64) + 1},
+		name: zf.Name,
+		size: zf.UncompressedSize64,
+	}
+	return fn(zf.Name, sr, zf.FileInfo())
+}
+
+// sizeReader reads the uncompressed contents of a file in a zip and returns an
+// error if they are larger or smaller than the declared size.
+type sizeReader struct {
+	r    io.Reader
+	name string
+	size uint64
+	read uint64
+}
+
+func (r *sizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += uint64(n)
+	if r.read > r.size {
+		return n, fmt.Errorf("uncompressed size of file %s is larger than declared size (%d bytes)", r.name, r.size)
+	}
+	// archive/zip reports a stream that ends early as an unexpected EOF.
+	if r.read < r.size && (err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return n, fmt.Errorf("uncompressed size of file %s is smaller than declared size (%d of %d bytes)", r.name, r.read, r.size)
+	}
+	return n, err
+}
@@ -0,0 +1,60 @@
+package contenthash
+
+import (
+	"fmt"
+	"path/filepath"
+	"unicode/utf16"
+)
+
+// windowsMaxPath is MAX_PATH, the maximum length of a path on Windows in
+// UTF-16 code units including the terminating NUL, unless long paths are
+// enabled. Go handles longer paths, but many other tools don't.
+const windowsMaxPath = 260
+
+// checkWindowsPaths returns a FileErrorList with the files whose path below dir
+// exceeds MAX_PATH.
+func checkWindowsPaths(dir string, files []extractedFile) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	var errs FileErrorList
+	for _, f := range files {
+		p := filepath.Join(abs, filepath.FromSlash(f.name))
+		if n := len(utf16.Encode([]rune(p))); n >= windowsMaxPath {
+			errs = append(errs, FileError{Path: f.zf.Name, Err: fmt.Errorf("extracted path is %d characters long; MAX_PATH on Windows allows %d", n, windowsMaxPath-1)})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// windowsModeWarnings returns warnings about the modes of files that can't be
+// honored on Windows, where the execute bits have no meaning and the write bits
+// are mapped to the read-only attribute.
+func windowsModeWarnings(files []extractedFile, o *options) []string {
+	var readOnly, executable int
+	for _, f := range files {
+		mode := o.fileMode(f.zf)
+		if mode&0200 == 0 {
+			readOnly++
+		}
+		if mode&0111 != 0 {
+			executable++
+		}
+	}
+	var warnings []string
+	if readOnly == 1 {
+		warnings = append(warnings, "1 file gets the read-only attribute, which prevents some tools from modifying or deleting it; extract it as writable to avoid this")
+	} else if readOnly > 1 {
+		warnings = append(warnings, fmt.Sprintf("%d files get the read-only attribute, which prevents some tools from modifying or deleting them; extract them as writable to avoid this", readOnly))
+	}
+	if executable == 1 {
+		warnings = append(warnings, "1 file is marked as executable, which has no effect on Windows")
+	} else if executable > 1 {
+		warnings = append(warnings, fmt.Sprintf("%d files are marked as executable, which has no effect on Windows", executable))
+	}
+	return warnings
+}
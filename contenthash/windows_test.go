@@ -0,0 +1,138 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWindowsModeWarnings(t *testing.T) {
+	const (
+		readOnly   = "2 files get the read-only attribute, which prevents some tools from modifying or deleting them; extract them as writable to avoid this"
+		executable = "1 file is marked as executable, which has no effect on Windows"
+	)
+	for _, tt := range []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{name: "readonly", want: []string{readOnly, executable}},
+		{name: "writable", opts: []Option{WithModePolicy(ModeWritable)}, want: []string{executable}},
+		{name: "preserve", opts: []Option{WithModePolicy(ModePreserve)}, want: []string{"1 file gets the read-only attribute, which prevents some tools from modifying or deleting it; extract it as writable to avoid this", executable}},
+		{name: "uniform", opts: []Option{WithUniformMode(0644)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			z := readZip(t, []testFile{{"a.go", ""}, {"run.sh", ""}})
+			z.File[0].SetMode(0644)
+			z.File[1].SetMode(0555)
+			files := []extractedFile{{zf: z.File[0], name: "a.go"}, {zf: z.File[1], name: "run.sh"}}
+			if got := windowsModeWarnings(files, newOptions(tt.opts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got warnings %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnzipWindowsModes(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("only Windows warns about file modes")
+	}
+	zipFile := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, h := range []*zip.FileHeader{{Name: "a.go"}, {Name: "run.bat"}} {
+		h.SetMode(0644)
+		if h.Name == "run.bat" {
+			h.SetMode(0755)
+		}
+		if _, err := zw.CreateHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	var warnings []string
+	if err := Unzip(dir, zipFile, "", WithOnWarning(func(msg string) { warnings = append(warnings, msg) })); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2 files get the read-only attribute, which prevents some tools from modifying or deleting them; extract them as writable to avoid this",
+		"1 file is marked as executable, which has no effect on Windows",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("got warnings %q, want %q", warnings, want)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0200 != 0 {
+		t.Errorf("a.go is writable, want read-only attribute")
+	}
+}
+
+func TestCheckWindowsPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("absolute paths have a volume name on Windows")
+	}
+	dir := "/" + strings.Repeat("d", 200)
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{name: strings.Repeat("f", 57)},
+		{name: "sub/" + strings.Repeat("f", 53)},
+		{name: strings.Repeat("f", 58), wantErr: strings.Repeat("f", 58) + ": extracted path is 260 characters long; MAX_PATH on Windows allows 259"},
+		{name: "sub/" + strings.Repeat("f", 60), wantErr: "sub/" + strings.Repeat("f", 60) + ": extracted path is 266 characters long; MAX_PATH on Windows allows 259"},
+		// Non-BMP characters take two UTF-16 code units.
+		{name: strings.Repeat("😀", 29), wantErr: strings.Repeat("😀", 29) + ": extracted path is 260 characters long; MAX_PATH on Windows allows 259"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			z := readZip(t, []testFile{{tt.name, ""}})
+			err := checkWindowsPaths(dir, []extractedFile{{zf: z.File[0], name: tt.name}})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkWindowsPaths: %v", err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnzipWindowsPathLength(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("MAX_PATH is only checked on Windows")
+	}
+	dir, err := filepath.Abs(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The path fits below dir, but not below the temporary directory next
+	// to it that the files are extracted to first.
+	name := strings.Repeat("f", windowsMaxPath-2-len(dir))
+	zipFile := writeZip(t, []testFile{{name, "data"}, {name + "g", "data"}})
+	err = Unzip(dir, zipFile, "")
+	want := fmt.Sprintf("%s: extracted path is %d characters long", name+"g", windowsMaxPath)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want error containing %q", err, want)
+	}
+	if strings.Contains(err.Error(), name+":") {
+		t.Errorf("%s was rejected although it fits below %s: %v", name, dir, err)
+	}
+}
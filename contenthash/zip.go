@@ -0,0 +1,1268 @@
+// Based on:
+// https://cs.opensource.google/go/x/mod/+/refs/tags/v0.12.0:zip/zip.go
+// Original license:
+// Copyright (c) 2009 The Go Authors. All rights reserved.
+//
+//	Redistribution and use in source and binary forms, with or without
+//	modification, are permitted provided that the following conditions are
+//	met:
+//
+//	   * Redistributions of source code must retain the above copyright
+//	notice, this list of conditions and the following disclaimer.
+//	   * Redistributions in binary form must reproduce the above
+//	copyright notice, this list of conditions and the following disclaimer
+//	in the documentation and/or other materials provided with the
+//	distribution.
+//	   * Neither the name of Google Inc. nor the names of its
+//	contributors may be used to endorse or promote products derived from
+//	this software without specific prior written permission.
+//
+//	THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+//	"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+//	LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
+//	A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+//	OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+//	SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
+//	LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+//	DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+//	THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+//	(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+//	OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+package contenthash
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxZipFile is the maximum size in bytes of a module zip file. The
+	// go command will report an error if either the zip file or its extracted
+	// content is larger than this.
+	MaxZipFile = 500 << 20
+
+	// MaxZipEntries is the default maximum number of entries in a zip file.
+	// The go command doesn't enforce a limit, but a huge number of tiny
+	// entries is a common way to exhaust resources while extracting.
+	MaxZipEntries = 100000
+
+	// MaxPathLength is the default maximum length in bytes of the path of an
+	// entry. It matches PATH_MAX on Linux, so that longer paths can't be
+	// extracted on common file systems anyway.
+	MaxPathLength = 4096
+
+	// maxExtraLen is the size in bytes above which the extra fields of a file
+	// are reported in strict mode. Common extra fields such as timestamps,
+	// Unix owners and zip64 sizes are well below this.
+	maxExtraLen = 64
+)
+
+// CheckedFiles reports whether a set of files satisfy the name and size
+// constraints required by module zip files. The constraints are listed in the
+// package documentation.
+//
+// See the documentation of CheckZip for details.
+type CheckedFiles struct {
+	// Valid is a list of file paths that should be included in a zip file.
+	Valid []string
+
+	// Omitted is a list of files that are ignored when creating a module zip
+	// file, along with the reason each file is ignored. With WithStrict, it
+	// also lists harmless anomalies such as an archive comment, in which case
+	// the affected files are still valid.
+	Omitted []FileError
+
+	// Invalid is a list of files that should not be included in a module zip
+	// file, along with the reason each file is invalid.
+	Invalid []FileError
+
+	// SizeError is non-nil if the total uncompressed size of the valid files
+	// exceeds the module zip size limit, if the zip file itself exceeds the
+	// limit or if it has more entries than allowed.
+	SizeError error
+
+	// ArchiveError is non-nil if the zip file as a whole violates a restriction
+	// that is not specific to a single file, such as a missing go.mod file.
+	ArchiveError error
+
+	// Unsorted is non-nil if the entries aren't sorted byte-wise by path, as
+	// they are in zips served by the module proxy, and describes the first
+	// pair of entries that is out of order. This is only informational unless
+	// WithStrict is given, in which case it is also reported in ArchiveError.
+	Unsorted error
+}
+
+// Err returns an error if [CheckedFiles] does not describe a valid module zip
+// file. [CheckedFiles.SizeError] is returned if that field is set, followed by
+// [CheckedFiles.ArchiveError]. A [FileErrorList] is returned
+// if there are one or more invalid files. Other errors may be returned in the
+// future.
+func (cf CheckedFiles) Err() error {
+	if cf.SizeError != nil {
+		return cf.SizeError
+	}
+	if cf.ArchiveError != nil {
+		return cf.ArchiveError
+	}
+	if len(cf.Invalid) > 0 {
+		return FileErrorList(cf.Invalid)
+	}
+	return nil
+}
+
+type FileErrorList []FileError
+
+func (el FileErrorList) Error() string {
+	buf := &strings.Builder{}
+	sep := ""
+	for _, e := range el {
+		buf.WriteString(sep)
+		buf.WriteString(e.Error())
+		sep = "\n"
+	}
+	return buf.String()
+}
+
+// A FileError describes a problem with a single file. Path is empty for
+// problems with the archive as a whole.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// CheckZip reports whether the zip archive read from r satisfies the
+// restrictions listed in the package documentation. size is the size of the
+// archive in bytes.
+//
+// CheckZip returns the opened *zip.Reader so that callers can extract files
+// without reading the central directory again. The returned CheckedFiles is
+// populated even if an error is returned, unless the archive could not be read
+// at all. The error is the one returned by [CheckedFiles.Err].
+func CheckZip(r io.ReaderAt, size int64, opts ...Option) (*zip.Reader, CheckedFiles, error) {
+	o := newOptions(opts)
+
+	// Check the total file size.
+	if size > o.maxSize {
+		cf := CheckedFiles{SizeError: fmt.Errorf("zip file is too large (%d bytes; limit is %d bytes)", size, o.maxSize)}
+		return nil, cf, cf.Err()
+	}
+
+	// Check for valid file names, collisions.
+	var cf CheckedFiles
+	addError := func(zf *zip.File, err error) {
+		cf.Invalid = append(cf.Invalid, FileError{Path: zf.Name, Err: err})
+	}
+	z, err := newZipReader(r, size, o)
+	if err != nil {
+		return nil, cf, err
+	}
+	if o.maxEntries > 0 && len(z.File) > o.maxEntries {
+		cf.SizeError = fmt.Errorf("zip file has too many entries (%d entries; limit is %d)", len(z.File), o.maxEntries)
+		return nil, cf, cf.Err()
+	}
+	if o.strict && z.Comment != "" {
+		cf.Omitted = append(cf.Omitted, FileError{Err: fmt.Errorf("zip has an archive comment of %d bytes", len(z.Comment))})
+	}
+	collisions := newCollisionChecker(o.caseSensitive || o.escapeCollisions)
+	// With WithCaseSensitive, case-insensitive collisions are only reported as
+	// warnings.
+	var folds *collisionChecker
+	if o.caseSensitive && o.onWarning != nil {
+		folds = newCollisionChecker(false)
+	}
+	var total int64
+	var prevName string
+	for _, zf := range z.File {
+		name := zf.Name
+		isDir := strings.HasSuffix(name, "/")
+		if isDir {
+			name = name[:len(name)-1]
+		}
+		if cf.Unsorted == nil && zf.Name < prevName {
+			cf.Unsorted = fmt.Errorf("entries are not sorted: %s is listed after %s", zf.Name, prevName)
+		}
+		prevName = zf.Name
+		if err := sanitizeName(name); err != nil {
+			addError(zf, err)
+			continue
+		}
+		if o.maxPathLen > 0 && len(name) > o.maxPathLen {
+			addError(zf, fmt.Errorf("file path is %d bytes long; limit is %d", len(name), o.maxPathLen))
+			continue
+		}
+		if err := checkFileMode(zf.Mode()); err != nil {
+			addError(zf, err)
+			continue
+		}
+		if err := checkMethod(zf.Method, o.extraCompression); err != nil {
+			addError(zf, err)
+			continue
+		}
+		if err := checkAllowedMethod(zf.Method, o.allowedMethods); err != nil {
+			addError(zf, err)
+			continue
+		}
+		if isDir {
+			if err := checkDirEntry(zf); err != nil {
+				addError(zf, err)
+				continue
+			}
+		}
+		if err := collisions.check(name, isDir); err != nil {
+			addError(zf, err)
+			continue
+		}
+		if folds != nil {
+			var collision *CollisionError
+			if err := folds.check(name, isDir); errors.As(err, &collision) {
+				o.onWarning(fmt.Sprintf("%q and %q can't both be extracted on case-insensitive file systems", collision.Other, collision.Path))
+			}
+		}
+		if isDir {
+			continue
+		}
+		if o.strict && len(zf.Extra) > maxExtraLen {
+			cf.Omitted = append(cf.Omitted, FileError{Path: zf.Name, Err: fmt.Errorf("file has %d bytes of extra fields", len(zf.Extra))})
+		}
+		if ratio := CompressionRatio(zf); o.maxRatio > 0 && ratio > o.maxRatio {
+			addError(zf, fmt.Errorf("compression ratio %.0f:1 exceeds the limit of %.0f:1", ratio, o.maxRatio))
+			continue
+		}
+		if err := o.validate(zf); err != nil {
+			addError(zf, err)
+			continue
+		}
+		sz := int64(zf.UncompressedSize64)
+		if sz >= 0 && o.maxSize-total >= sz {
+			total += sz
+		} else if cf.SizeError == nil {
+			cf.SizeError = fmt.Errorf("total uncompressed size of module contents too large (max size is %d bytes)", o.maxSize)
+		}
+		cf.Valid = append(cf.Valid, zf.Name)
+	}
+
+	var archiveErrs []error
+	if o.strict && cf.Unsorted != nil {
+		archiveErrs = append(archiveErrs, cf.Unsorted)
+	}
+	if !o.allowEmpty && !hasFiles(z) {
+		archiveErrs = append(archiveErrs, errors.New("zip contains no files"))
+	}
+	if o.canonical {
+		if err := checkCanonical(cf.Valid); err != nil {
+			archiveErrs = append(archiveErrs, err)
+		}
+	}
+	if o.requireGoMod {
+		if err := checkGoMod(z, cf.Valid); err != nil {
+			archiveErrs = append(archiveErrs, err)
+		}
+	}
+	if o.expectedModule.Path != "" {
+		if err := checkModule(cf.Valid, o.expectedModule); err != nil {
+			archiveErrs = append(archiveErrs, err)
+		}
+	}
+	cf.ArchiveError = errors.Join(archiveErrs...)
+
+	return z, cf, cf.Err()
+}
+
+// CompressionRatio returns the ratio of the uncompressed to the compressed
+// size of zf. It is infinite for a non-empty file with a compressed size of
+// zero and zero for an empty file.
+func CompressionRatio(zf *zip.File) float64 {
+	if zf.UncompressedSize64 == 0 {
+		return 0
+	}
+	if zf.CompressedSize64 == 0 {
+		return math.Inf(1)
+	}
+	return float64(zf.UncompressedSize64) / float64(zf.CompressedSize64)
+}
+
+// validate returns the first error reported for zf by the validators passed
+// to WithValidator.
+func (o *options) validate(zf *zip.File) error {
+	for _, v := range o.validators {
+		if err := v(zf.Name, int64(zf.UncompressedSize64), zf.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasFiles reports whether z contains at least one entry that is not a
+// directory.
+func hasFiles(z *zip.Reader) bool {
+	for _, zf := range z.File {
+		if !strings.HasSuffix(zf.Name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFileMode returns an error if mode, as stored in a zip file, describes
+// an entry that can't be extracted as a regular file or directory. Besides
+// symlinks, this includes devices, named pipes, sockets and irregular files,
+// the latter of which archivers use for hardlinks and Windows reparse points.
+func checkFileMode(mode fs.FileMode) error {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return fmt.Errorf("symlinks are not allowed (mode %v)", mode)
+	case mode&fs.ModeDevice != 0:
+		return fmt.Errorf("device files are not allowed (mode %v)", mode)
+	case mode&fs.ModeNamedPipe != 0:
+		return fmt.Errorf("named pipes are not allowed (mode %v)", mode)
+	case mode&fs.ModeSocket != 0:
+		return fmt.Errorf("sockets are not allowed (mode %v)", mode)
+	case mode.Type()&^fs.ModeDir != 0:
+		return fmt.Errorf("only regular files and directories are allowed (mode %v)", mode)
+	}
+	return nil
+}
+
+// checkDirEntry returns an error if the directory entry zf carries data, which
+// would be silently dropped on extraction.
+func checkDirEntry(zf *zip.File) error {
+	if zf.UncompressedSize64 != 0 {
+		return fmt.Errorf("directory entry has non-zero size %d", zf.UncompressedSize64)
+	}
+	if zf.Method != zip.Store {
+		return fmt.Errorf("directory entry is compressed (method %d)", zf.Method)
+	}
+	return nil
+}
+
+// Unzip extracts the contents of the module zip file zipFile to dir.
+//
+// If prefix is non-empty, it is a comma-separated list of directory prefixes.
+// Only files below one of these directories are extracted and the first
+// matching prefix is stripped from their paths. A prefix may contain
+// path.Match patterns, e.g., "example.com/*" matches and strips any directory
+// directly below example.com. It is an error if a prefix matches no file. If
+// prefix is AutoPrefix, the prefix is detected from the files in the zip.
+//
+// Unzip checks all restrictions listed in the package documentation and returns
+// an error if the zip archive is not valid. Files are extracted to a temporary
+// directory next to dir, which is moved into place only after all files have
+// been written successfully. If an error is returned, dir is left untouched.
+//
+// dir may or may not exist: Unzip will create it and any missing parent
+// directories if it doesn't exist. If dir exists, it must be empty unless
+// WithForce is given, in which case its contents are replaced. Unzip fails
+// before reading the zip file if it can't write next to dir.
+func Unzip(dir, zipFile, prefix string, opts ...Option) error {
+	return UnzipContext(context.Background(), dir, zipFile, prefix, opts...)
+}
+
+// UnzipContext is like Unzip, but stops extracting and cleans up as soon as
+// ctx is done, in which case the error returned by ctx.Err() is returned.
+func UnzipContext(ctx context.Context, dir, zipFile, prefix string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() {
+		if err != nil {
+			err = &zipError{verb: "unzip", path: zipFile, err: err}
+		}
+	}()
+
+	if o.cacheLayout && prefix != "" {
+		return errors.New("cannot strip a prefix with the module cache layout")
+	}
+	// Check that the directory is empty. Don't create it yet in case there's
+	// an error reading the zip. With WithCacheLayout and WithHashSubdir, the
+	// target directory depends on the contents of the zip and is checked
+	// below. With WithSkipIfExtracted, whether a directory that isn't empty
+	// already contains the files is also only known after reading the zip.
+	subdir := o.cacheLayout || o.hashSubdir
+	if !subdir && !mayBeExtracted(dir, o) {
+		if err := checkTarget(dir, o); err != nil {
+			return err
+		}
+	}
+	// Fail before doing any work if the temporary directory can't be created
+	// next to dir, or below dir if the files are extracted to a subdirectory.
+	if !o.dryRun && (subdir || !mayBeExtracted(dir, o)) {
+		if err := checkWritableTarget(dir, subdir); err != nil {
+			return err
+		}
+	}
+
+	prefixes, err := newPrefixMatcher(prefix)
+	if err != nil {
+		return err
+	}
+
+	// Open the zip and check that it satisfies all restrictions.
+	f, r, err := openSection(zipFile, o)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var stats Stats
+	sw := stopwatch{enabled: o.onStats != nil}
+	sw.reset()
+	z, cf, err := CheckZip(r, r.Size(), opts...)
+	stats.Check = sw.elapsed()
+	var hash string
+	if z != nil {
+		// Report a hash mismatch before any other problems.
+		sw.reset()
+		var hashErr error
+		if hash, hashErr = o.verifyHash(z); hashErr != nil {
+			return hashErr
+		}
+		if hash != "" {
+			stats.Hash = sw.elapsed()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if o.onStats != nil {
+		stats.Entries, stats.Bytes = zipStats(z)
+		defer func() {
+			if err == nil {
+				stats.Extract = sw.elapsed()
+				o.onStats(stats)
+			}
+		}()
+	}
+	var base string
+	if o.hashSubdir {
+		base = hashDirName(hash)
+	}
+	if o.cacheLayout {
+		// Extract the files below the module@version directory to the
+		// corresponding directory in the module cache.
+		modPrefix, modDir, err := moduleCacheDir(cf.Valid)
+		if err != nil {
+			return err
+		}
+		base = path.Join(base, modDir)
+		if prefixes, err = newPrefixMatcher(modPrefix); err != nil {
+			return err
+		}
+	}
+	if subdir {
+		dir = filepath.Join(dir, filepath.FromSlash(base))
+	}
+	if mayBeExtracted(dir, o) {
+		files, err := selectFiles(z, prefixes, o)
+		if err != nil {
+			return err
+		}
+		if alreadyExtracted(dir, files) {
+			stats.Skipped = true
+			if o.onWarning != nil {
+				o.onWarning(fmt.Sprintf("skipping extraction since %v already contains the files", dir))
+			}
+			return nil
+		}
+		// dir has unrelated contents and is only replaced with WithForce.
+		if err := checkTarget(dir, o); err != nil {
+			return err
+		}
+		if !o.dryRun && !subdir {
+			if err := checkWritableTarget(dir, false); err != nil {
+				return err
+			}
+		}
+	} else if subdir {
+		if err := checkTarget(dir, o); err != nil {
+			return err
+		}
+	}
+	if runtime.GOOS == "windows" && o.maxPathLen > 0 {
+		// Check the paths below dir rather than below the longer temporary
+		// directory the files are extracted to first.
+		files, err := selectFiles(z, prefixes, o)
+		if err != nil {
+			return err
+		}
+		if err := checkWindowsPaths(dir, files); err != nil {
+			return err
+		}
+	}
+	sw.reset()
+	if o.dryRun {
+		if stats.Written, err = extractFiles(ctx, dir, base, z, prefixes, o); err != nil {
+			return err
+		}
+		return checkWritten(z, stats.Written, prefix, o)
+	}
+
+	// Extract into a temporary sibling directory so that dir is only populated
+	// once all files have been written successfully.
+	parent := filepath.Dir(dir)
+	if err := mkdirAll(parent, o.dirMode); err != nil {
+		return err
+	}
+	tmp, err := makeTempDir(parent, "."+filepath.Base(dir)+".tmp-", o.tempSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	// The temporary directory is created with mode 0700.
+	if err := os.Chmod(tmp, o.dirMode); err != nil {
+		return err
+	}
+	if stats.Written, err = extractFiles(ctx, tmp, base, z, prefixes, o); err != nil {
+		return relocateErrors(err, tmp, dir)
+	}
+	if err := checkWritten(z, stats.Written, prefix, o); err != nil {
+		return err
+	}
+	if o.fsync {
+		if err := syncTree(tmp); err != nil {
+			return err
+		}
+	}
+	move := moveDir
+	if o.force {
+		// Keep the previous contents of dir until the files are in place.
+		move = replaceDir
+	}
+	if err := move(tmp, dir, o.fsync); err != nil {
+		return err
+	}
+	if o.fsync {
+		return syncDir(parent)
+	}
+	return nil
+}
+
+// UnzipFile writes the contents of the single file name in the module zip file
+// zipFile to w. If prefix is non-empty, name is relative to the matching
+// prefix, as for Unzip.
+//
+// UnzipFile checks all restrictions listed in the package documentation before
+// writing anything and returns an error if the file's uncompressed size
+// differs from its declared size.
+func UnzipFile(w io.Writer, zipFile, prefix, name string, opts ...Option) (err error) {
+	o := newOptions(opts)
+	defer func() {
+		if err != nil {
+			err = &zipError{verb: "unzip", path: zipFile, err: err}
+		}
+	}()
+
+	prefixes, err := newPrefixMatcher(prefix)
+	if err != nil {
+		return err
+	}
+	f, z, err := checkedZip(zipFile, o, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := prefixes.resolve(z); err != nil {
+		return err
+	}
+
+	var similar []string
+	for _, zf := range z.File {
+		if strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		rel, ok := prefixes.strip(zf.Name)
+		if !ok {
+			continue
+		}
+		if rel == name {
+			x := &extractor{o: o}
+			_, err := x.copyFile(context.Background(), w, zf)
+			return err
+		}
+		if path.Base(rel) == path.Base(name) {
+			similar = append(similar, rel)
+		}
+	}
+	if len(similar) > 0 {
+		return fmt.Errorf("file %s not found, did you mean %s?", name, strings.Join(similar, " or "))
+	}
+	return fmt.Errorf("file %s not found", name)
+}
+
+// verifyHash returns an error if a hash was passed to WithExpectedHash and it
+// doesn't match the content hash of z. Otherwise, the hash is reported to the
+// function passed to WithOnHash, if any, and returned. The hash is only
+// computed if one of the options requires it.
+func (o *options) verifyHash(z *zip.Reader) (string, error) {
+	if o.expectedHash == "" && o.onHash == nil && !o.hashSubdir {
+		return "", nil
+	}
+	hash, err := HashFiles(z)
+	if err != nil {
+		return "", err
+	}
+	if o.expectedHash != "" && hash != o.expectedHash {
+		return "", &HashMismatchError{Got: hash, Want: o.expectedHash}
+	}
+	if o.onHash != nil {
+		o.onHash(hash)
+	}
+	return hash, nil
+}
+
+// hashDirName returns the name of the directory that WithHashSubdir extracts
+// a zip file with the given "h1:" hash to.
+func hashDirName(hash string) string {
+	digest := strings.TrimPrefix(hash, "h1:")
+	return "h1-" + strings.NewReplacer("+", "-", "/", "_").Replace(digest)
+}
+
+// relocateErrors rewrites the paths of *fs.PathErrors in err from below the
+// temporary directory tmp to the corresponding paths below dir, so that
+// errors refer to the files the user asked for and don't depend on the random
+// name of tmp.
+func relocateErrors(err error, tmp, dir string) error {
+	relocate := func(err error) {
+		var pe *fs.PathError
+		if !errors.As(err, &pe) {
+			return
+		}
+		if rel, relErr := filepath.Rel(tmp, pe.Path); relErr == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			pe.Path = filepath.Join(dir, rel)
+		}
+	}
+	var el FileErrorList
+	if errors.As(err, &el) {
+		for _, e := range el {
+			relocate(e.Err)
+		}
+	} else {
+		relocate(err)
+	}
+	return err
+}
+
+// makeTempDir creates a new directory in parent whose name is prefix followed
+// by suffix, or by a random string if suffix is empty, and returns its path.
+func makeTempDir(parent, prefix, suffix string) (string, error) {
+	if suffix == "" {
+		return os.MkdirTemp(parent, prefix)
+	}
+	if strings.ContainsAny(suffix, `/\`) {
+		return "", fmt.Errorf("temporary directory suffix %q contains a path separator", suffix)
+	}
+	tmp := filepath.Join(parent, prefix+suffix)
+	if err := os.Mkdir(tmp, 0700); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// checkTarget returns an error if dir can't be used as the target directory of
+// Unzip.
+func checkTarget(dir string, o *options) error {
+	if o.force {
+		return checkForceTarget(dir)
+	}
+	if !isEmptyDir(dir) {
+		return fmt.Errorf("%w: %v", ErrDirNotEmpty, dir)
+	}
+	return nil
+}
+
+// checkWritableTarget returns an error if the temporary directory can't be
+// created next to dir, or below dir if subdir is set.
+func checkWritableTarget(dir string, subdir bool) error {
+	writableDir := filepath.Dir(dir)
+	if subdir {
+		writableDir = dir
+	}
+	if err := checkWritable(writableDir); err != nil {
+		return fmt.Errorf("target directory %v is not writable: %w", dir, err)
+	}
+	return nil
+}
+
+// isEmptyDir reports whether dir is an empty directory or doesn't exist.
+func isEmptyDir(dir string) bool {
+	files, _ := os.ReadDir(dir)
+	return len(files) == 0
+}
+
+// mayBeExtracted reports whether WithSkipIfExtracted is given and dir isn't
+// empty, in which case alreadyExtracted decides whether to skip extraction
+// once the files to extract are known.
+func mayBeExtracted(dir string, o *options) bool {
+	return o.skipIfExtracted && !isEmptyDir(dir)
+}
+
+// alreadyExtracted reports whether dir already contains the given files as
+// extracted by Unzip. If the files share a common directory, such as the
+// module@version directory if no prefix is stripped, that directory must exist
+// and not be empty. Otherwise, all of their top-level files and directories
+// must exist. Unrelated contents of dir don't count.
+func alreadyExtracted(dir string, files []extractedFile) bool {
+	if len(files) == 0 {
+		return false
+	}
+	common := path.Dir(files[0].name)
+	for _, f := range files[1:] {
+		for common != "." && !strings.HasPrefix(f.name, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	if common != "." {
+		return !isEmptyDir(filepath.Join(dir, filepath.FromSlash(common)))
+	}
+	for _, f := range files {
+		top, _, _ := strings.Cut(f.name, "/")
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(top))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkForceTarget returns an error if dir must not be replaced by Unzip even
+// if WithForce is given.
+func checkForceTarget(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(abs) == abs {
+		return fmt.Errorf("refusing to replace root directory %v", dir)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to replace symlink %v", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("target %v exists and is not a directory", dir)
+	}
+	return nil
+}
+
+// extractFiles extracts the files in z matched by prefixes to dir, enforcing
+// sizes declared in the zip file. base is the slash-separated path of dir
+// relative to the target directory passed to Unzip, which is prepended to the
+// paths reported to WithOnExtract. It returns the number of bytes written.
+func extractFiles(ctx context.Context, dir, base string, z *zip.Reader, prefixes *prefixMatcher, o *options) (int64, error) {
+	files, err := selectFiles(z, prefixes, o)
+	if err != nil {
+		return 0, err
+	}
+
+	if o.onWarning != nil && runtime.GOOS == "windows" {
+		for _, w := range windowsModeWarnings(files, o) {
+			o.onWarning(w)
+		}
+	}
+
+	x := &extractor{dir: dir, base: base, o: o, limiter: newRateLimiter(o.rateLimit), dirs: make(map[string]bool)}
+	if o.onProgress != nil {
+		x.progress = &progress{fn: o.onProgress, total: totalSize(files), mu: &x.mu}
+	}
+	if o.jobs <= 1 {
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			if err := x.fail(ctx, f, x.extractFile(ctx, f)); err != nil {
+				return 0, err
+			}
+		}
+	} else if err := x.extractParallel(ctx, files, o.jobs); err != nil {
+		return 0, err
+	}
+	if len(x.errs) > 0 {
+		sort.Slice(x.errs, func(i, j int) bool {
+			return x.errs[i].Path < x.errs[j].Path
+		})
+		return 0, x.errs
+	}
+	return x.written, nil
+}
+
+// checkWritten returns an error if all files in z have been extracted with
+// prefix and o, but the number of bytes written doesn't match the total size
+// of the files checked by CheckZip. Otherwise, the files skipped due to the
+// prefix or a filter account for the difference.
+func checkWritten(z *zip.Reader, written int64, prefix string, o *options) error {
+	if prefix != "" || o.cacheLayout || o.stripComponents > 0 || o.include != nil || len(o.exclude) > 0 || o.metadataOnly {
+		return nil
+	}
+	if _, total := zipStats(z); written != total {
+		return fmt.Errorf("extracted %d bytes, but the files in the zip file have a total size of %d bytes", written, total)
+	}
+	return nil
+}
+
+// selectFiles returns the files in z that are matched by prefixes and the
+// filters in o, along with their paths relative to the target directory.
+func selectFiles(z *zip.Reader, prefixes *prefixMatcher, o *options) ([]extractedFile, error) {
+	if err := prefixes.resolve(z); err != nil {
+		return nil, err
+	}
+	var files []extractedFile
+	filter, err := newFileFilter(o)
+	if err != nil {
+		return nil, err
+	}
+	for _, zf := range z.File {
+		if zf.Name == "" || strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+		name, ok := prefixes.strip(zf.Name)
+		if !ok || !filter.match(name) {
+			continue
+		}
+		if o.stripComponents > 0 {
+			elems := strings.SplitN(name, "/", o.stripComponents+1)
+			if len(elems) <= o.stripComponents {
+				if o.strict {
+					return nil, fmt.Errorf("cannot strip %d path components from file %s", o.stripComponents, zf.Name)
+				}
+				continue
+			}
+			name = elems[o.stripComponents]
+		}
+		if name, err = rewriteName(name, o.rewrites); err != nil {
+			return nil, err
+		}
+		files = append(files, extractedFile{zf: zf, name: name})
+	}
+	if err := prefixes.err(); err != nil {
+		return nil, err
+	}
+	if o.strict {
+		if err := filter.err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.rewrites) > 0 {
+		if err := checkRewritten(files); err != nil {
+			return nil, err
+		}
+	}
+	if o.escapeCollisions {
+		escapeCollisions(files)
+	}
+	return files, nil
+}
+
+// escapeCollisions renames files whose paths are equal under Unicode
+// case-folding to the path of an earlier file by appending "~" and a short
+// hash of their name in the zip.
+func escapeCollisions(files []extractedFile) {
+	seen := make(map[string]bool, len(files))
+	for i := range files {
+		f := &files[i]
+		name := f.name
+		if seen[strToFold(name)] {
+			sum := sha256.Sum256([]byte(f.zf.Name))
+			suffix := hex.EncodeToString(sum[:4])
+			name = f.name + "~" + suffix
+			// Extend the suffix in the unlikely case that the escaped path
+			// collides as well.
+			for n := 5; seen[strToFold(name)] && n <= len(sum); n++ {
+				name = f.name + "~" + hex.EncodeToString(sum[:n])
+			}
+			f.name = name
+		}
+		seen[strToFold(name)] = true
+	}
+}
+
+// checkedZip opens zipFile and checks that it satisfies all restrictions and,
+// if requested, has the expected hash. The caller must close c.
+func checkedZip(zipFile string, o *options, opts []Option) (c io.Closer, z *zip.Reader, err error) {
+	f, r, err := openSection(zipFile, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	z, _, err = CheckZip(r, r.Size(), opts...)
+	if z != nil {
+		// Report a hash mismatch before any other problems.
+		if _, err := o.verifyHash(z); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, z, nil
+}
+
+// extractedFile is a file in the zip together with its path relative to the
+// target directory.
+type extractedFile struct {
+	zf   *zip.File
+	name string
+}
+
+// extractor writes files to a directory. It is safe for concurrent use.
+type extractor struct {
+	dir      string
+	base     string
+	o        *options
+	progress *progress
+	limiter  *rateLimiter
+
+	mu      sync.Mutex      // guards dirs, errs, written and calls to user-provided functions
+	dirs    map[string]bool // directories known to exist
+	errs    FileErrorList   // errors collected with WithContinueOnError
+	written int64           // bytes written to extracted files
+}
+
+// fail returns err, the result of extracting f, unless WithContinueOnError is
+// given, in which case err is recorded and nil is returned so that extraction
+// continues. Cancellation always stops extraction.
+func (x *extractor) fail(ctx context.Context, f extractedFile, err error) error {
+	if err == nil || !x.o.continueOnError || ctx.Err() != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.errs = append(x.errs, FileError{Path: f.zf.Name, Err: err})
+	return nil
+}
+
+// extractParallel extracts files using the given number of goroutines. The
+// first error stops all goroutines.
+func (x *extractor) extractParallel(ctx context.Context, files []extractedFile, jobs int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	ch := make(chan extractedFile)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range ch {
+				if err := x.fail(ctx, f, x.extractFile(ctx, f)); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+send:
+	for _, f := range files {
+		select {
+		case ch <- f:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(ch)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// mkdirAll creates dir and its parents, remembering which directories have
+// already been created.
+func (x *extractor) mkdirAll(dir string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirs[dir] {
+		return nil
+	}
+	if err := mkdirAll(dir, x.o.dirMode); err != nil {
+		return err
+	}
+	x.dirs[dir] = true
+	return nil
+}
+
+func (x *extractor) extractFile(ctx context.Context, f extractedFile) error {
+	zf := f.zf
+	mode := x.o.fileMode(zf)
+	if x.o.dryRun {
+		if err := sanitizeName(f.name); err != nil {
+			return err
+		}
+		n, sum, err := x.copyAndHash(ctx, io.Discard, zf)
+		if err != nil {
+			return err
+		}
+		x.extracted(ExtractedFile{Name: zf.Name, Path: path.Join(x.base, f.name), Size: n, Mode: mode, SHA256: sum})
+		return nil
+	}
+
+	dst, err := safeJoin(x.dir, f.name)
+	if err != nil {
+		return err
+	}
+	if err := x.mkdirAll(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	n, sum, err := x.copyAndHash(ctx, w, zf)
+	if err == nil && x.o.fsync {
+		err = w.Sync()
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if x.o.modePolicy == ModePreserve || x.o.uniform {
+		// The mode passed to OpenFile is subject to the umask.
+		if err := os.Chmod(dst, mode); err != nil {
+			return err
+		}
+	}
+	if mtime, ok := x.o.mtime(zf); ok {
+		if err := os.Chtimes(dst, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	x.extracted(ExtractedFile{Name: zf.Name, Path: path.Join(x.base, f.name), Size: n, Mode: mode, SHA256: sum})
+	return nil
+}
+
+// copyAndHash is like copyFile, but also returns the hex-encoded SHA-256
+// digest of the contents if WithSHA256 is given.
+func (x *extractor) copyAndHash(ctx context.Context, w io.Writer, zf *zip.File) (int64, string, error) {
+	if !x.o.sha256 {
+		n, err := x.copyFile(ctx, w, zf)
+		return n, "", err
+	}
+	h := sha256.New()
+	n, err := x.copyFile(ctx, io.MultiWriter(w, h), zf)
+	if err != nil {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mtime returns the modification time of zf if it should be preserved. Times
+// before 1980 can't be represented in the MS-DOS format and indicate a missing
+// or invalid timestamp.
+func (o *options) mtime(zf *zip.File) (time.Time, bool) {
+	if !o.preserveMtime || zf.Modified.Year() < 1980 {
+		return time.Time{}, false
+	}
+	return zf.Modified, true
+}
+
+// copyFile copies the uncompressed contents of zf to w and returns the number
+// of bytes copied. It returns an error if the contents are larger than the size
+// declared in the zip file.
+func (x *extractor) copyFile(ctx context.Context, w io.Writer, zf *zip.File) (int64, error) {
+	r, err := openFile(zf)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	lr := &io.LimitedReader{R: x.limiter.reader(ctx, ctxReader{ctx, r}), N: int64(zf.UncompressedSize64) + 1}
+	n, err := io.Copy(x.progress.writer(w), lr)
+	// archive/zip reports a stream that ends early as an unexpected EOF.
+	if uint64(n) < zf.UncompressedSize64 && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return n, fmt.Errorf("uncompressed size of file %s is smaller than declared size (%d of %d bytes)", zf.Name, n, zf.UncompressedSize64)
+	}
+	if err != nil {
+		return n, err
+	}
+	if lr.N <= 0 {
+		return n, fmt.Errorf("uncompressed size of file %s is larger than declared size (%d bytes)", zf.Name, zf.UncompressedSize64)
+	}
+	return n, nil
+}
+
+// extracted reports a file that has been extracted successfully.
+func (x *extractor) extracted(f ExtractedFile) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.written += f.Size
+	if x.o.onExtract != nil {
+		x.o.onExtract(f)
+	}
+}
+
+// ctxReader is an io.Reader that fails once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// collisionChecker finds case-insensitive name collisions and paths that
+// are listed as both files and directories.
+type collisionChecker struct {
+	// paths maps each path processed with strToFold, or as is if
+	// caseSensitive is set, to the original path.
+	paths map[string]pathInfo
+	// caseSensitive disables the detection of case-insensitive collisions.
+	caseSensitive bool
+}
+
+func newCollisionChecker(caseSensitive bool) *collisionChecker {
+	return &collisionChecker{paths: make(map[string]pathInfo), caseSensitive: caseSensitive}
+}
+
+type pathInfo struct {
+	path  string
+	isDir bool
+	// explicit is true if the path has its own entry in the zip file rather
+	// than only being the parent directory of another entry.
+	explicit bool
+}
+
+func (cc *collisionChecker) check(p string, isDir bool) error {
+	return cc.add(p, isDir, true)
+}
+
+func (cc *collisionChecker) add(p string, isDir, explicit bool) error {
+	fold := p
+	if !cc.caseSensitive {
+		fold = strToFold(p)
+	}
+	if other, ok := cc.paths[fold]; ok {
+		if p != other.path {
+			return &CollisionError{Other: other.path, Path: p, Folded: fold}
+		}
+		if isDir != other.isDir {
+			return fmt.Errorf("entry %q is both a file and a directory", p)
+		}
+		if !isDir {
+			return fmt.Errorf("multiple entries for file %q", p)
+		}
+		if explicit && other.explicit {
+			return fmt.Errorf("multiple entries for directory %q", p)
+		}
+		// It's not an error if add is called with the same directory multiple
+		// times as a parent. add is called recursively on parent directories,
+		// so add may be called on the same directory many times.
+		if explicit {
+			other.explicit = true
+			cc.paths[fold] = other
+		}
+	} else {
+		cc.paths[fold] = pathInfo{path: p, isDir: isDir, explicit: explicit}
+	}
+
+	if parent := path.Dir(p); parent != "." {
+		return cc.add(parent, true, false)
+	}
+	return nil
+}
+
+type zipError struct {
+	verb, path string
+	err        error
+}
+
+func (e *zipError) Error() string {
+	if e.path == "" {
+		return fmt.Sprintf("%s: %v", e.verb, e.err)
+	} else {
+		return fmt.Sprintf("%s %s: %v", e.verb, e.path, e.err)
+	}
+}
+
+func (e *zipError) Unwrap() error {
+	return e.err
+}
+
+// strToFold returns a string with the property that
+//
+//	strings.EqualFold(s, t) iff strToFold(s) == strToFold(t)
+//
+// This lets us test a large set of strings for fold-equivalent
+// duplicates without making a quadratic number of calls
+// to EqualFold. Note that strings.ToUpper and strings.ToLower
+// do not have the desired property in some corner cases.
+func strToFold(s string) string {
+	// Fast path: all ASCII, no upper case.
+	// Most paths look like this already.
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= utf8.RuneSelf || 'A' <= c && c <= 'Z' {
+			goto Slow
+		}
+	}
+	return s
+
+Slow:
+	var buf bytes.Buffer
+	for _, r := range s {
+		// SimpleFold(x) cycles to the next equivalent rune > x
+		// or wraps around to smaller values. Iterate until it wraps,
+		// and we've found the minimum value.
+		for {
+			r0 := r
+			r = unicode.SimpleFold(r0)
+			if r <= r0 {
+				break
+			}
+		}
+		// Exception to allow fast path above: A-Z => a-z
+		if 'A' <= r && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,644 @@
+package contenthash
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testFile is a file in a zip file created by writeZip.
+type testFile struct {
+	name    string
+	content string
+}
+
+// zipBytes returns a zip file that contains files in the given order.
+func zipBytes(t testing.TB, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeZip writes a zip file that contains files to a temporary directory and
+// returns its path.
+func writeZip(t testing.TB, files []testFile) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(p, zipBytes(t, files), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// readZip returns a reader for a zip file that contains files.
+func readZip(t testing.TB, files []testFile) *zip.Reader {
+	t.Helper()
+	b := zipBytes(t, files)
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+var moduleFiles = []testFile{
+	{"example.com/m@v1.0.0/go.mod", "module example.com/m\n"},
+	{"example.com/m@v1.0.0/m.go", "package m\n"},
+	{"example.com/m@v1.0.0/sub/sub.go", "package sub\n"},
+}
+
+func TestUnzipWritten(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		prefix  string
+		opts    []Option
+		written int64
+	}{
+		{name: "all files", written: 43},
+		{name: "prefix", prefix: "example.com/m@v1.0.0/sub", written: 12},
+		{name: "prefix pattern", prefix: "example.com/*", written: 43},
+		{name: "exclude", opts: []Option{WithExclude([]string{"**/sub"})}, written: 31},
+		{name: "metadata only", prefix: AutoPrefix, opts: []Option{WithMetadataOnly()}, written: 21},
+		{name: "dry run with prefix", prefix: "example.com/m@v1.0.0/sub", opts: []Option{WithDryRun()}, written: 12},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			zipFile := writeZip(t, moduleFiles)
+			var stats Stats
+			opts := append([]Option{WithStats(func(s Stats) { stats = s })}, tt.opts...)
+			if err := Unzip(filepath.Join(t.TempDir(), "out"), zipFile, tt.prefix, opts...); err != nil {
+				t.Fatal(err)
+			}
+			if stats.Bytes != 43 {
+				t.Errorf("got Bytes %d, want 43", stats.Bytes)
+			}
+			if stats.Written != tt.written {
+				t.Errorf("got Written %d, want %d", stats.Written, tt.written)
+			}
+		})
+	}
+}
+
+func TestCheckWritten(t *testing.T) {
+	z := readZip(t, moduleFiles)
+	for _, tt := range []struct {
+		name    string
+		prefix  string
+		opts    []Option
+		written int64
+		wantErr bool
+	}{
+		{name: "all files", written: 43},
+		{name: "missing bytes", written: 42, wantErr: true},
+		{name: "extra bytes", written: 44, wantErr: true},
+		{name: "prefix", prefix: "example.com/m@v1.0.0/sub", written: 12},
+		{name: "include", opts: []Option{WithInclude([]string{})}, written: 0},
+		{name: "strip components", opts: []Option{WithStripComponents(2)}, written: 31},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWritten(z, tt.written, tt.prefix, newOptions(tt.opts))
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("checkWritten(%d) = %v, want error: %v", tt.written, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// checkZip calls CheckZip on a zip file that contains files.
+func checkZip(t *testing.T, files []testFile, opts ...Option) (CheckedFiles, error) {
+	t.Helper()
+	b := zipBytes(t, files)
+	_, cf, err := CheckZip(bytes.NewReader(b), int64(len(b)), opts...)
+	return cf, err
+}
+
+func TestCheckZipDuplicates(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   []testFile
+		invalid string
+		wantErr string
+	}{
+		{
+			name:    "duplicate file",
+			files:   []testFile{{"foo.txt", "a"}, {"foo.txt", "b"}},
+			invalid: "foo.txt",
+			wantErr: `multiple entries for file "foo.txt"`,
+		},
+		{
+			name:    "duplicate directory",
+			files:   []testFile{{"dir/", ""}, {"dir/a.go", "a"}, {"dir/", ""}},
+			invalid: "dir/",
+			wantErr: `multiple entries for directory "dir"`,
+		},
+		{
+			name:    "file and directory",
+			files:   []testFile{{"foo", "a"}, {"foo/", ""}},
+			invalid: "foo/",
+			wantErr: `entry "foo" is both a file and a directory`,
+		},
+		{
+			name:  "implicit and explicit directory",
+			files: []testFile{{"dir/a.go", "a"}, {"dir/", ""}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := checkZip(t, tt.files)
+			if tt.invalid == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+				return
+			}
+			if len(cf.Invalid) != 1 {
+				t.Fatalf("got invalid files %v, want only %s", cf.Invalid, tt.invalid)
+			}
+			if got := cf.Invalid[0]; got.Path != tt.invalid || got.Err.Error() != tt.wantErr {
+				t.Errorf("got invalid file %s: %v, want %s: %s", got.Path, got.Err, tt.invalid, tt.wantErr)
+			}
+			if err == nil {
+				t.Error("CheckZip succeeded")
+			}
+		})
+	}
+}
+
+func TestCheckZipDirEntry(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		method  uint16
+		data    string
+		wantErr string
+	}{
+		{name: "empty", method: zip.Store},
+		{name: "payload", method: zip.Store, data: "hidden", wantErr: "directory entry has non-zero size 6"},
+		{name: "compressed", method: zip.Deflate, wantErr: "directory entry is compressed (method 8)"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.Create("dir/a.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte("package a\n")); err != nil {
+				t.Fatal(err)
+			}
+			// zip.Writer doesn't write data for directories, so write a file
+			// named "dirX" and rename it to "dir/" below.
+			if w, err = zw.CreateHeader(&zip.FileHeader{Name: "dirX", Method: tt.method}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(tt.data)); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b := bytes.ReplaceAll(buf.Bytes(), []byte("dirX"), []byte("dir/"))
+			_, cf, err := CheckZip(bytes.NewReader(b), int64(len(b)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+				return
+			}
+			if len(cf.Invalid) != 1 || cf.Invalid[0].Path != "dir/" || cf.Invalid[0].Err.Error() != tt.wantErr {
+				t.Errorf("got invalid files %v, want dir/: %s", cf.Invalid, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckZipInvalidNames(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "\uFEFFgo.mod", wantErr: `file path contains a byte order mark: "\ufeffgo.mod"`},
+		{name: "dir/\uFEFFa.go", wantErr: `file path contains a byte order mark: "dir/\ufeffa.go"`},
+		{name: "a\xff.go", wantErr: `file path is not valid UTF-8: "a\xff.go"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := checkZip(t, []testFile{{"ok.go", "package ok\n"}, {tt.name, "data"}})
+			if err == nil {
+				t.Fatal("CheckZip succeeded")
+			}
+			if len(cf.Invalid) != 1 || cf.Invalid[0].Path != tt.name || cf.Invalid[0].Err.Error() != tt.wantErr {
+				t.Errorf("got invalid files %q, want %q: %s", cf.Invalid, tt.name, tt.wantErr)
+			}
+			if !reflect.DeepEqual(cf.Valid, []string{"ok.go"}) {
+				t.Errorf("got valid files %v, want [ok.go]", cf.Valid)
+			}
+		})
+	}
+}
+
+func TestCheckZipEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   []testFile
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "no entries", wantErr: true},
+		{name: "only directories", files: []testFile{{"a/", ""}, {"a/b/", ""}}, wantErr: true},
+		{name: "allowed", opts: []Option{WithAllowEmpty()}},
+		{name: "empty file", files: []testFile{{"a", ""}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkZip(t, tt.files, tt.opts...)
+			if tt.wantErr {
+				if err == nil || err.Error() != "zip contains no files" {
+					t.Errorf("got error %v, want zip contains no files", err)
+				}
+			} else if err != nil {
+				t.Errorf("CheckZip: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnzipEmpty(t *testing.T) {
+	zipFile := writeZip(t, nil)
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := Unzip(dir, zipFile, ""); err == nil {
+		t.Error("Unzip succeeded without WithAllowEmpty")
+	}
+	if err := Unzip(dir, zipFile, "", WithAllowEmpty()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %v, %v, want empty directory", entries, err)
+	}
+}
+
+func TestUnzipSizeMismatch(t *testing.T) {
+	const content = "package a\n"
+	for _, tt := range []struct {
+		name     string
+		method   uint16
+		declared uint64
+		wantErr  string
+	}{
+		{name: "stored exact", method: zip.Store, declared: uint64(len(content))},
+		{name: "deflated exact", method: zip.Deflate, declared: uint64(len(content))},
+		{name: "stored too small", method: zip.Store, declared: 100, wantErr: "uncompressed size of file a.go is smaller than declared size (10 of 100 bytes)"},
+		// archive/zip drops the bytes read together with io.EOF when it
+		// reports the unexpected EOF.
+		{name: "deflated too small", method: zip.Deflate, declared: 100, wantErr: "uncompressed size of file a.go is smaller than declared size"},
+		// archive/zip itself fails with zip.ErrFormat as soon as more
+		// bytes than declared are read.
+		{name: "stored too large", method: zip.Store, declared: 4, wantErr: zip.ErrFormat.Error()},
+		{name: "deflated too large", method: zip.Deflate, declared: 4, wantErr: zip.ErrFormat.Error()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Compress the file as usual and copy it with a doctored
+			// uncompressed size in its headers.
+			zr := readZip(t, []testFile{{"a.go", content}})
+			fh := zr.File[0].FileHeader
+			if tt.method == zip.Store {
+				fh.Method = zip.Store
+				fh.CompressedSize64 = uint64(len(content))
+			}
+			fh.UncompressedSize64 = tt.declared
+			var raw []byte
+			if tt.method == zip.Store {
+				raw = []byte(content)
+			} else {
+				r, err := zr.File[0].OpenRaw()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if raw, err = io.ReadAll(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fh.Flags &^= 0x8 // no data descriptor
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.CreateRaw(&fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(raw); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			zipFile := filepath.Join(t.TempDir(), "test.zip")
+			if err := os.WriteFile(zipFile, buf.Bytes(), 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := filepath.Join(t.TempDir(), "out")
+			err = Unzip(dir, zipFile, "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want error containing %q", err, tt.wantErr)
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("Unzip created %s after failing", dir)
+			}
+		})
+	}
+}
+
+func TestCheckZipMaxEntries(t *testing.T) {
+	files := []testFile{{"a/", ""}, {"a/b.go", "b"}, {"a/c.go", "c"}}
+	for _, tt := range []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{name: "default"},
+		{name: "at limit", opts: []Option{WithMaxEntries(3)}},
+		{name: "above limit", opts: []Option{WithMaxEntries(2)}, wantErr: "zip file has too many entries (3 entries; limit is 2)"},
+		{name: "directories count", opts: []Option{WithMaxEntries(1)}, wantErr: "zip file has too many entries (3 entries; limit is 1)"},
+		{name: "unlimited", opts: []Option{WithMaxEntries(0)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := checkZip(t, files, tt.opts...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+				return
+			}
+			if cf.SizeError == nil || cf.SizeError.Error() != tt.wantErr {
+				t.Errorf("got SizeError %v, want %s", cf.SizeError, tt.wantErr)
+			}
+			if err != cf.SizeError {
+				t.Errorf("got error %v, want SizeError", err)
+			}
+		})
+	}
+}
+
+func TestUnzipMaxEntries(t *testing.T) {
+	files := make([]testFile, MaxZipEntries+1)
+	for i := range files {
+		files[i] = testFile{name: fmt.Sprintf("f%d", i)}
+	}
+	dir := filepath.Join(t.TempDir(), "out")
+	err := Unzip(dir, writeZip(t, files), "")
+	if err == nil || !strings.Contains(err.Error(), "zip file has too many entries") {
+		t.Errorf("got error %v, want too many entries", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Unzip created %s after failing", dir)
+	}
+}
+
+func TestCheckZipCollisions(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		a, b   string
+		opts   []Option
+		folded string
+	}{
+		{name: "ascii", a: "README", b: "readme", folded: "readme"},
+		{name: "directories", a: "Dir/a.go", b: "dir/b.go", folded: "dir"},
+		{name: "kelvin sign", a: "k.go", b: "K.go", folded: "k.go"},
+		{name: "long s", a: "s.go", b: "ſ.go", folded: "s.go"},
+		// Dotted capital I has no simple case folding, so it doesn't
+		// collide with i.
+		{name: "dotted capital i", a: "i.go", b: "İ.go"},
+		{name: "dotless i", a: "I.go", b: "ı.go"},
+		{name: "case-sensitive", a: "README", b: "readme", opts: []Option{WithCaseSensitive()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := checkZip(t, []testFile{{tt.a, "a"}, {tt.b, "b"}}, tt.opts...)
+			if tt.folded == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+				return
+			}
+			if len(cf.Invalid) != 1 {
+				t.Fatalf("got invalid files %v, want %s", cf.Invalid, tt.b)
+			}
+			var collision *CollisionError
+			if !errors.As(cf.Invalid[0].Err, &collision) {
+				t.Fatalf("got error %v, want CollisionError", cf.Invalid[0].Err)
+			}
+			want := CollisionError{Other: path.Dir(tt.a), Path: path.Dir(tt.b), Folded: tt.folded}
+			if path.Dir(tt.a) == "." {
+				want.Other, want.Path = tt.a, tt.b
+			}
+			if *collision != want {
+				t.Errorf("got %+v, want %+v", *collision, want)
+			}
+		})
+	}
+}
+
+func TestCheckZipFileModes(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		mode    fs.FileMode
+		wantErr string
+	}{
+		{name: "regular", mode: 0644},
+		{name: "executable", mode: 0755},
+		{name: "symlink", mode: fs.ModeSymlink | 0777, wantErr: "symlinks are not allowed (mode Lrwxrwxrwx)"},
+		{name: "block device", mode: fs.ModeDevice | 0644, wantErr: "device files are not allowed (mode Drw-r--r--)"},
+		{name: "char device", mode: fs.ModeDevice | fs.ModeCharDevice | 0644, wantErr: "device files are not allowed (mode Dcrw-r--r--)"},
+		{name: "named pipe", mode: fs.ModeNamedPipe | 0644, wantErr: "named pipes are not allowed (mode prw-r--r--)"},
+		{name: "socket", mode: fs.ModeSocket | 0644, wantErr: "sockets are not allowed (mode Srw-r--r--)"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			h := &zip.FileHeader{Name: "f"}
+			h.SetMode(tt.mode)
+			if _, err := zw.CreateHeader(h); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			_, cf, err := CheckZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+				return
+			}
+			if len(cf.Invalid) != 1 || cf.Invalid[0].Path != "f" || cf.Invalid[0].Err.Error() != tt.wantErr {
+				t.Errorf("got invalid files %v, want f: %s", cf.Invalid, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCheckFileModeIrregular covers the mode bits that archive/zip doesn't
+// produce from Unix modes.
+func TestCheckFileModeIrregular(t *testing.T) {
+	for _, mode := range []fs.FileMode{fs.ModeIrregular | 0644, fs.ModeIrregular | fs.ModeDir | 0755} {
+		if err := checkFileMode(mode); err == nil || !strings.Contains(err.Error(), "only regular files and directories are allowed") {
+			t.Errorf("checkFileMode(%v) = %v, want error", mode, err)
+		}
+	}
+}
+
+func TestCheckZipCaseSensitive(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		files         []testFile
+		caseSensitive bool
+		wantErr       string
+		wantWarnings  []string
+	}{
+		{
+			name:    "fold collision",
+			files:   []testFile{{"README", "a"}, {"readme", "b"}},
+			wantErr: `case-insensitive file name collision: "README" and "readme"`,
+		},
+		{
+			name:          "fold collision allowed",
+			files:         []testFile{{"README", "a"}, {"readme", "b"}},
+			caseSensitive: true,
+			wantWarnings:  []string{`"README" and "readme" can't both be extracted on case-insensitive file systems`},
+		},
+		{
+			name:          "exact duplicate",
+			files:         []testFile{{"README", "a"}, {"README", "b"}},
+			caseSensitive: true,
+			wantErr:       `multiple entries for file "README"`,
+		},
+		{
+			name:          "file and directory",
+			files:         []testFile{{"a", "a"}, {"a/b", "b"}},
+			caseSensitive: true,
+			wantErr:       `entry "a" is both a file and a directory`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []string
+			opts := []Option{WithOnWarning(func(msg string) { warnings = append(warnings, msg) })}
+			if tt.caseSensitive {
+				opts = append(opts, WithCaseSensitive())
+			}
+			cf, err := checkZip(t, tt.files, opts...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckZip: %v", err)
+				}
+			} else if len(cf.Invalid) != 1 || cf.Invalid[0].Err.Error() != tt.wantErr {
+				t.Errorf("got invalid files %v, want %s", cf.Invalid, tt.wantErr)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestUnzipCaseSensitive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "PROBE"), nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "probe")); err == nil {
+		t.Skip("the file system is case-insensitive")
+	}
+	zipFile := writeZip(t, []testFile{{"README", "upper"}, {"readme", "lower"}})
+	if err := Unzip(dir, zipFile, ""); err == nil {
+		t.Error("Unzip succeeded without WithCaseSensitive")
+	}
+	if err := Unzip(dir, zipFile, "", WithCaseSensitive()); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"README": "upper", "readme": "lower"} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("got %s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestUnzipStatsHash(t *testing.T) {
+	zipFile := writeZip(t, moduleFiles)
+	hash, err := HashZip(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		wantHash bool
+	}{
+		{name: "no hash"},
+		{name: "expected hash", opts: []Option{WithExpectedHash(hash)}, wantHash: true},
+		{name: "on hash", opts: []Option{WithOnHash(func(string) {})}, wantHash: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats Stats
+			opts := append([]Option{WithStats(func(s Stats) { stats = s })}, tt.opts...)
+			if err := Unzip(filepath.Join(t.TempDir(), "out"), zipFile, "", opts...); err != nil {
+				t.Fatal(err)
+			}
+			if got := stats.Hash > 0; got != tt.wantHash {
+				t.Errorf("got Hash %v, want non-zero: %v", stats.Hash, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestUnzipCacheLayoutBuild(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	cache := t.TempDir()
+	if err := Unzip(cache, writeZip(t, moduleFiles), "", WithCacheLayout()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Build a module that imports both packages of the extracted module
+	// through a replace directive, without any network access.
+	main := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": fmt.Sprintf("module example.com/main\n\ngo 1.21\n\nrequire example.com/m v1.0.0\n\nreplace example.com/m => %s\n",
+			filepath.ToSlash(filepath.Join(cache, "example.com", "m@v1.0.0"))),
+		"main.go": "package main\n\nimport (\n\t_ \"example.com/m\"\n\t_ \"example.com/m/sub\"\n)\n\nfunc main() {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(main, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "build", "./...")
+	cmd.Dir = main
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GOTOOLCHAIN=local")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+}
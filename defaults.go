@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultConfigFile is the config file that is used if it exists in the
+// working directory and -config isn't given.
+const defaultConfigFile = ".chunzip.toml"
+
+// flagDefaults holds default flag values read from a config file, e.g.:
+//
+//	max-size = "1G"
+//	hash-algo = "h1"
+//
+//	[extract]
+//	mode = "writable"
+//	exclude = ["**/testdata"]
+//
+// Top-level keys apply to all commands that have a flag of that name, keys in
+// a table only to the command with the table's name. Flags given on the
+// command line take precedence.
+type flagDefaults struct {
+	global   map[string][]string
+	commands map[string]map[string][]string
+}
+
+// loadDefaults reads the config file given by a leading -config flag in args,
+// or the default config file if it exists, and returns the remaining args.
+// It returns nil defaults if there is no config file.
+func loadDefaults(args []string) ([]string, *flagDefaults, error) {
+	var path string
+	switch {
+	case len(args) >= 2 && (args[0] == "-config" || args[0] == "--config"):
+		path, args = args[1], args[2:]
+	case len(args) >= 1 && (strings.HasPrefix(args[0], "-config=") || strings.HasPrefix(args[0], "--config=")):
+		_, path, _ = strings.Cut(args[0], "=")
+		args = args[1:]
+	default:
+		if _, err := os.Stat(defaultConfigFile); errors.Is(err, fs.ErrNotExist) {
+			return args, nil, nil
+		}
+		path = defaultConfigFile
+	}
+	var raw map[string]any
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, nil, fmt.Errorf("reading config: %w", err)
+	}
+	d, err := parseDefaults(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return args, d, nil
+}
+
+func parseDefaults(raw map[string]any) (*flagDefaults, error) {
+	known := knownFlags()
+	d := &flagDefaults{
+		global:   make(map[string][]string),
+		commands: make(map[string]map[string][]string),
+	}
+	// Iterate in a fixed order so that the same error is reported for a
+	// config file with multiple problems.
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := raw[key]
+		if table, ok := value.(map[string]any); ok {
+			names, ok := known[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown command %q", key)
+			}
+			values := make(map[string][]string)
+			flags := make([]string, 0, len(table))
+			for name := range table {
+				flags = append(flags, name)
+			}
+			sort.Strings(flags)
+			for _, name := range flags {
+				value := table[name]
+				if !names[name] {
+					return nil, fmt.Errorf("command %s has no flag -%s", key, name)
+				}
+				if values[name], ok = flagValues(value); !ok {
+					return nil, fmt.Errorf("invalid value for %s.%s", key, name)
+				}
+			}
+			d.commands[key] = values
+			continue
+		}
+		found := false
+		for _, names := range known {
+			found = found || names[key]
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown flag -%s", key)
+		}
+		var ok bool
+		if d.global[key], ok = flagValues(value); !ok {
+			return nil, fmt.Errorf("invalid value for %s", key)
+		}
+	}
+	return d, nil
+}
+
+// flagValues returns the flag values for a config value, which is a single
+// value or, for repeatable flags, an array of values.
+func flagValues(value any) ([]string, bool) {
+	switch v := value.(type) {
+	case string, int64, float64, bool:
+		return []string{fmt.Sprint(v)}, true
+	case []any:
+		var values []string
+		for _, e := range v {
+			ev, ok := flagValues(e)
+			if !ok || len(ev) != 1 {
+				return nil, false
+			}
+			values = append(values, ev[0])
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// knownFlags returns the names of the flags of each command, where the legacy
+// command line has the empty name.
+func knownFlags() map[string]map[string]bool {
+	known := make(map[string]map[string]bool)
+	add := func(name string, fs *flag.FlagSet) {
+		names := make(map[string]bool)
+		fs.VisitAll(func(f *flag.Flag) {
+			names[f.Name] = true
+		})
+		known[name] = names
+	}
+	for _, cmd := range commands {
+		add(cmd.name, cmd.flagSet(new(config)))
+	}
+	add("", legacyFlagSet(new(config)))
+	return known
+}
+
+// apply sets the defaults for the command with the given name on the flags of
+// fs that weren't given on the command line. It must be called after fs has
+// been parsed.
+func (d *flagDefaults) apply(fs *flag.FlagSet, command string) error {
+	if d == nil {
+		return nil
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	// Command-specific defaults take precedence over global ones.
+	values := make(map[string][]string)
+	for name, v := range d.global {
+		if fs.Lookup(name) != nil {
+			values[name] = v
+		}
+	}
+	for name, v := range d.commands[command] {
+		values[name] = v
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if given[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("config: invalid value %q for flag -%s: %w", v, name, err)
+			}
+		}
+	}
+	return nil
+}
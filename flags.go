@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+)
+
+// byteSize is a flag.Value for a number of bytes with an optional binary unit
+// suffix such as "500M" or "1G".
+type byteSize int64
+
+var byteSizeSuffixes = []struct {
+	suffix string
+	shift  uint
+}{
+	{"K", 10},
+	{"M", 20},
+	{"G", 30},
+	{"T", 40},
+}
+
+func (b *byteSize) String() string {
+	n := int64(*b)
+	for i := len(byteSizeSuffixes) - 1; i >= 0; i-- {
+		s := byteSizeSuffixes[i]
+		if n != 0 && n%(1<<s.shift) == 0 {
+			return fmt.Sprintf("%d%s", n>>s.shift, s.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	digits := strings.TrimSuffix(strings.ToUpper(s), "B")
+	var shift uint
+	for _, suffix := range byteSizeSuffixes {
+		if strings.HasSuffix(digits, suffix.suffix) {
+			digits = strings.TrimSuffix(digits, suffix.suffix)
+			shift = suffix.shift
+			break
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	if n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+	return n << shift, nil
+}
+
+// fileMode is a flag.Value for octal file permissions such as "0755".
+type fileMode os.FileMode
+
+func (m *fileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *fileMode) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid permissions %q", s)
+	}
+	*m = fileMode(n)
+	return nil
+}
+
+// rewriteRule is a prefix substitution given with -rewrite.
+type rewriteRule struct {
+	from, to string
+}
+
+// rewriteList is a flag.Value for FROM:TO rules, which may be given multiple
+// times.
+type rewriteList []rewriteRule
+
+func (l *rewriteList) String() string {
+	rules := make([]string, 0, len(*l))
+	for _, r := range *l {
+		rules = append(rules, r.from+":"+r.to)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (l *rewriteList) Set(s string) error {
+	from, to, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("invalid rewrite rule %q, must have the form FROM:TO", s)
+	}
+	*l = append(*l, rewriteRule{from: from, to: to})
+	return nil
+}
+
+// methodList is a flag.Value for a comma-separated list of compression methods
+// given by name or ID.
+type methodList []uint16
+
+func (l *methodList) String() string {
+	names := make([]string, 0, len(*l))
+	for _, m := range *l {
+		names = append(names, strconv.Itoa(int(m)))
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *methodList) Set(s string) error {
+	var methods methodList
+	for _, name := range strings.Split(s, ",") {
+		m, err := contenthash.ParseMethod(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		methods = append(methods, m)
+	}
+	*l = methods
+	return nil
+}
+
+// stringList is a flag.Value for a flag that may be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -1,5 +1,11 @@
 module github.com/fmeum/content_hash_unzip
 
-go 1.20
+go 1.21
 
-require golang.org/x/mod v0.12.0
+require (
+	github.com/klauspost/compress v1.16.7
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/mod v0.12.0
+)
+
+require github.com/BurntSushi/toml v1.3.2
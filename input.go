@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+)
+
+// downloadAttempts is the number of times a download is attempted before
+// giving up on transient errors.
+const downloadAttempts = 3
+
+// localZip returns the path of a local file with the contents of the zip file
+// referred to by arg, which is either a path, "-" for stdin or an HTTP(S) URL.
+// If the file is gzip-compressed, it is decompressed first, failing once the
+// decompressed file is larger than -max-size, before it fills the disk.
+// cleanup removes any temporary files and must be called when the file is no
+// longer needed.
+func (c *config) localZip(ctx context.Context, arg string) (path string, cleanup func(), err error) {
+	path, cleanup, err = fetchZip(ctx, arg, c.timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	limit := c.sizeLimit()
+	if isGzip(path) {
+		path, cleanup, err = convertFile(path, cleanup, func(r io.Reader) (io.Reader, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return &sizeLimitReader{r: zr, limit: limit}, nil
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("decompressing %s: %w", arg, err)
+		}
+	}
+	return path, cleanup, nil
+}
+
+// inputZip is like localZip for the <zip> argument. With -from-tar, arg is the
+// tar archive and the zip file is read from the member given by -member, which
+// is rejected before reading it if it is larger than -max-size.
+func (c *config) inputZip(ctx context.Context, arg string) (path string, cleanup func(), err error) {
+	path, cleanup, err = c.localZip(ctx, arg)
+	if err != nil || c.member == "" {
+		return path, cleanup, err
+	}
+	path, cleanup, err = convertFile(path, cleanup, func(r io.Reader) (io.Reader, error) {
+		return tarMember(r, c.member, c.sizeLimit())
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("reading %s from %s: %w", c.member, arg, err)
+	}
+	return path, cleanup, nil
+}
+
+// fromTarArgs returns args with the -from-tar archive inserted as the <zip>
+// argument, which is omitted if -from-tar is given.
+func (c *config) fromTarArgs(args []string) ([]string, error) {
+	switch {
+	case c.fromTar == "" && c.member == "":
+		return args, nil
+	case c.fromTar == "":
+		return nil, errors.New("-member requires -from-tar")
+	case c.member == "":
+		return nil, errors.New("-from-tar requires -member")
+	}
+	return append([]string{c.fromTar}, args...), nil
+}
+
+// sizeLimit returns the maximum size of a zip file given by -max-size, or
+// MaxZipFile for the commands without that flag.
+func (c *config) sizeLimit() int64 {
+	if c.maxSize <= 0 {
+		return contenthash.MaxZipFile
+	}
+	return int64(c.maxSize)
+}
+
+// convertFile replaces the file at path by a temporary file with the contents
+// of the reader that convert returns for it. cleanup is called for the
+// original file in any case.
+func convertFile(path string, cleanup func(), convert func(io.Reader) (io.Reader, error)) (string, func(), error) {
+	defer cleanup()
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	r, err := convert(f)
+	if err != nil {
+		return "", nil, err
+	}
+	converted, err := bufferToTemp(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return converted, func() { os.Remove(converted) }, nil
+}
+
+// tarMember returns a reader for the contents of the regular file name in the
+// tar archive read from r. Members larger than limit bytes are rejected
+// before reading them.
+func tarMember(r io.Reader, name string, limit int64) (io.Reader, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no such member in the tar archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(hdr.Name) != path.Clean(name) {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, errors.New("member is not a regular file")
+		}
+		if hdr.Size > limit {
+			return nil, fmt.Errorf("member is too large (%d bytes; limit is %d bytes)", hdr.Size, limit)
+		}
+		// The tar reader doesn't read beyond the size in the header.
+		return tr, nil
+	}
+}
+
+// sizeLimitReader reads from r and fails once more than limit bytes have been
+// read, unlike io.LimitReader, which silently truncates.
+type sizeLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	// Read at most one byte beyond the limit to detect that it is exceeded.
+	if rest := l.limit - l.read + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf("decompressed file is larger than %d bytes", l.limit)
+	}
+	return n, err
+}
+
+// isGzip reports whether the file at path starts with the gzip magic bytes.
+// Errors are left to the code that reads the zip file.
+func isGzip(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [2]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return magic == [2]byte{0x1f, 0x8b}
+}
+
+// fetchZip is like localZip, but doesn't decompress gzip-compressed files.
+func fetchZip(ctx context.Context, arg string, timeout time.Duration) (path string, cleanup func(), err error) {
+	switch {
+	case arg == "-":
+		path, err = bufferToTemp(os.Stdin)
+		if err != nil {
+			return "", nil, fmt.Errorf("reading zip from stdin: %w", err)
+		}
+	case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+		path, err = download(ctx, arg, timeout)
+		if err != nil {
+			return "", nil, fmt.Errorf("downloading %s: %w", arg, err)
+		}
+	default:
+		return arg, func() {}, nil
+	}
+	return path, func() { os.Remove(path) }, nil
+}
+
+// bufferToTemp copies r into a temporary file and returns its path, since
+// reading a zip file requires random access. The caller is responsible for
+// removing the file.
+func bufferToTemp(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "content_hash_unzip-*.zip")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// download downloads url into a temporary file and returns its path. Server
+// errors and network errors are retried with exponential backoff.
+func download(ctx context.Context, url string, timeout time.Duration) (string, error) {
+	client := &http.Client{Timeout: timeout}
+	backoff := time.Second
+	var err error
+	for attempt := 1; ; attempt++ {
+		var path string
+		path, err = downloadOnce(ctx, client, url)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || ctx.Err() != nil || attempt == downloadAttempts {
+			return path, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// permanentError is an error that is not resolved by retrying a download.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func downloadOnce(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", &permanentError{err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return "", err
+		}
+		return "", &permanentError{err}
+	}
+	return bufferToTemp(resp.Body)
+}
+
+// readLines returns the non-empty lines of the file at path with surrounding
+// whitespace removed.
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// setupLogging installs the default logger for the diagnostics of the tool as
+// selected by -log-format and -log-level. The details printed with -v are
+// logged at the debug level, which is the default level with -v.
+func (c *config) setupLogging() error {
+	level := slog.LevelInfo
+	if c.logLevel == "" && c.verbose {
+		level = slog.LevelDebug
+	} else if c.logLevel != "" {
+		if err := level.UnmarshalText([]byte(c.logLevel)); err != nil {
+			return fmt.Errorf("invalid -log-level %q, must be debug, info, warn or error", c.logLevel)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch c.logFormat {
+	case "plain":
+		h = newPlainHandler(os.Stderr, level)
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log-format %q, must be plain, text or json", c.logFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// plainHandler is a slog.Handler that only writes the message of each record,
+// prefixed with "warning: " for warnings. Callers thus include everything a
+// human needs in the message and only add attributes for the structured
+// formats.
+type plainHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+func newPlainHandler(w io.Writer, level slog.Leveler) *plainHandler {
+	return &plainHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if r.Level == slog.LevelWarn {
+		b.WriteString("warning: ")
+	}
+	b.WriteString(r.Message)
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *plainHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -1,406 +1,608 @@
-// Based on:
-// https://cs.opensource.google/go/x/mod/+/refs/tags/v0.12.0:zip/zip.go
-// Original license:
-// Copyright (c) 2009 The Go Authors. All rights reserved.
-//
-//	Redistribution and use in source and binary forms, with or without
-//	modification, are permitted provided that the following conditions are
-//	met:
-//
-//	   * Redistributions of source code must retain the above copyright
-//	notice, this list of conditions and the following disclaimer.
-//	   * Redistributions in binary form must reproduce the above
-//	copyright notice, this list of conditions and the following disclaimer
-//	in the documentation and/or other materials provided with the
-//	distribution.
-//	   * Neither the name of Google Inc. nor the names of its
-//	contributors may be used to endorse or promote products derived from
-//	this software without specific prior written permission.
-//
-//	THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
-//	"AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
-//	LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR
-//	A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
-//	OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
-//	SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT
-//	LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
-//	DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
-//	THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
-//	(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
-//	OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path"
-	"path/filepath"
+	"runtime"
 	"strings"
-	"unicode"
-	"unicode/utf8"
+	"text/tabwriter"
 
-	"golang.org/x/mod/module"
-	"golang.org/x/mod/sumdb/dirhash"
+	"github.com/fmeum/content_hash_unzip/contenthash"
 )
 
-func main() {
-	if err := run(os.Args[1:]); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-}
+const usage = `usage: content_hash_unzip [-config <file>] <command> [flags] <args>
+
+commands:
+  hash <zip>                       print the content hash of <zip>
+  hash-all <zip>...                print the content hash and path of each <zip>, hashing them in parallel
+  hashdir <dir> [<prefix>]         print the content hash of the files in <dir> as if they were below
+                                   the directory <prefix> in a zip, e.g., module@version
+  check <zip>                      check that <zip> is a valid module zip file
+  list <zip>                       print the size and path of each file in <zip>
+  stat <zip>                       print statistics about the files in <zip>
+  extract <zip> <dir> [<prefix>]   extract the files below <prefix> in <zip> to <dir>, or to a tar
+                                   archive with -o <file>, in which case <dir> is omitted
+  cat <zip> <path> [<prefix>]      write the file at <path> below <prefix> in <zip> to stdout
+  canonicalize <zip> <out.zip>     write a byte-stable version of <zip> with the same hash to <out.zip>
+  diff <a.zip> <b.zip>             list the files that differ between <a.zip> and <b.zip>
+
+Run content_hash_unzip <command> -h for the flags of a command.
+Default flag values are read from the TOML <file>, or from .chunzip.toml in the
+working directory if it exists.
+<zip> may be - to read the zip file from stdin or an HTTP(S) URL to download it, and
+may be gzip-compressed. It is omitted with -from-tar.
+<prefix> may be a comma-separated list of prefixes, which may contain glob patterns,
+or @auto to strip the module@version directory or single top-level directory.
+The exit code is 2 if the hash doesn't match, 3 if the target directory isn't
+empty, 4 if a prefix matches no file and 1 for all other errors, including
+differences found by diff.
+
+If the first argument isn't a command, the legacy form is accepted:
+` + legacyUsage
+
+const legacyUsage = `usage: [flags] <zip> [<hash> <dir> [<strip_prefix>]]
+
+With -extract, <dir> is omitted: <zip> [<hash> [<strip_prefix>]].
+With -sumline, <hash> is omitted and the hash is verified even if no <dir> is given.
+An empty <hash> isn't compared, but the computed hash is printed to stderr.`
 
-func run(args []string) error {
-	if len(args) != 1 && len(args) != 3 && len(args) != 4 {
-		return fmt.Errorf("usage: <zip> [<hash> <dir> [<strip_prefix>]]")
+func main() {
+	slog.SetDefault(slog.New(newPlainHandler(os.Stderr, slog.LevelInfo)))
+	args, defaults, err := loadDefaults(os.Args[1:])
+	if err == nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = run(ctx, args, defaults)
+		stop()
 	}
-
-	zipFile := args[0]
-	hash, err := hashZip(zipFile)
 	if err != nil {
-		return err
-	}
-	if len(args) == 1 {
-		f, err := os.Open(zipFile)
-		if err != nil {
-			return err
+		var quiet quietError
+		if !errors.As(err, &quiet) {
+			slog.Error(err.Error(), "exit_code", exitCode(err))
 		}
-		defer f.Close()
-		_, err = checkZip(f)
-		if err != nil {
-			return err
-		}
-		fmt.Println(hash)
-		return nil
+		os.Exit(exitCode(err))
 	}
+}
 
-	expectedHash := args[1]
-	if hash != expectedHash {
-		return fmt.Errorf("got hash %s, expected %s", hash, expectedHash)
-	}
+// quietError wraps an error that is only reported through the exit code.
+type quietError struct {
+	err error
+}
 
-	dir := args[2]
-	var prefix string
-	if len(args) == 4 {
-		prefix = args[3]
-	}
-	return unzip(dir, zipFile, prefix)
+func (e quietError) Error() string {
+	return e.err.Error()
 }
 
-func hashZip(zip string) (string, error) {
-	return dirhash.HashZip(zip, dirhash.Hash1)
+func (e quietError) Unwrap() error {
+	return e.err
 }
 
+// Exit codes that allow scripts to distinguish between failures.
 const (
-	// MaxZipFile is the maximum size in bytes of a module zip file. The
-	// go command will report an error if either the zip file or its extracted
-	// content is larger than this.
-	MaxZipFile = 500 << 20
+	exitError        = 1
+	exitHashMismatch = 2
+	exitDirNotEmpty  = 3
+	exitNoPrefix     = 4
 )
 
-// CheckedFiles reports whether a set of files satisfy the name and size
-// constraints required by module zip files. The constraints are listed in the
-// package documentation.
-//
-// Functions that produce this report may include slightly different sets of
-// files. See documentation for CheckFiles, CheckDir, and CheckZip for details.
-type CheckedFiles struct {
-	// Valid is a list of file paths that should be included in a zip file.
-	Valid []string
-
-	// Omitted is a list of files that are ignored when creating a module zip
-	// file, along with the reason each file is ignored.
-	Omitted []FileError
-
-	// Invalid is a list of files that should not be included in a module zip
-	// file, along with the reason each file is invalid.
-	Invalid []FileError
-
-	// SizeError is non-nil if the total uncompressed size of the valid files
-	// exceeds the module zip size limit or if the zip file itself exceeds the
-	// limit.
-	SizeError error
-}
-
-// Err returns an error if [CheckedFiles] does not describe a valid module zip
-// file. [CheckedFiles.SizeError] is returned if that field is set.
-// A [FileErrorList] is returned
-// if there are one or more invalid files. Other errors may be returned in the
-// future.
-func (cf CheckedFiles) Err() error {
-	if cf.SizeError != nil {
-		return cf.SizeError
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, contenthash.ErrHashMismatch):
+		return exitHashMismatch
+	case errors.Is(err, contenthash.ErrDirNotEmpty):
+		return exitDirNotEmpty
+	case errors.Is(err, contenthash.ErrNoPrefixMatch):
+		return exitNoPrefix
+	default:
+		return exitError
 	}
-	if len(cf.Invalid) > 0 {
-		return FileErrorList(cf.Invalid)
-	}
-	return nil
-}
-
-type FileErrorList []FileError
-
-func (el FileErrorList) Error() string {
-	buf := &strings.Builder{}
-	sep := ""
-	for _, e := range el {
-		buf.WriteString(sep)
-		buf.WriteString(e.Error())
-		sep = "\n"
-	}
-	return buf.String()
 }
 
-type FileError struct {
-	Path string
-	Err  error
+// command is a subcommand of the CLI.
+type command struct {
+	name string
+	args string
+	// minArgs and maxArgs bound the number of positional arguments. A negative
+	// maxArgs doesn't limit the number.
+	minArgs, maxArgs int
+	// register registers the flags of the command.
+	register func(c *config, fs *flag.FlagSet)
+	// noZip is set if the first argument doesn't refer to a zip file.
+	noZip bool
+	// run runs the command with the given positional arguments. Unless noZip
+	// is set, the first argument refers to a zip file, whose local path is
+	// zipFile.
+	run func(ctx context.Context, c *config, zipFile string, args []string) error
 }
 
-func (e FileError) Error() string {
-	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+var commands = []command{
+	{
+		name:    "hash",
+		args:    "<zip>",
+		minArgs: 1,
+		maxArgs: 1,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerOut(fs)
+			fs.BoolVar(&c.filesHash, "files-hash", false, "print the per-file SHA-256 lines that make up the h1 hash instead of the hash")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			return c.hash(zipFile, c.filesHash)
+		},
+	},
+	{
+		name:    "hashdir",
+		args:    "<dir> [<prefix>]",
+		minArgs: 1,
+		maxArgs: 2,
+		noZip:   true,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerOut(fs)
+			fs.StringVar(&c.expectedHash, "hash", "", "expected `hash` of the directory, or env:VARNAME to read it from an environment variable")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			var prefix string
+			if len(args) == 2 {
+				prefix = args[1]
+			}
+			return c.hashDir(args[0], prefix)
+		},
+	},
+	{
+		name:    "hash-all",
+		args:    "<zip>...",
+		minArgs: 1,
+		maxArgs: -1,
+		// Each zip file is fetched by hashAll so that a failure only
+		// affects that file.
+		noZip: true,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			fs.IntVar(&c.jobs, "j", runtime.GOMAXPROCS(0), "number of zip files to hash concurrently")
+		},
+		run: func(ctx context.Context, c *config, _ string, args []string) error {
+			return c.hashAll(ctx, args)
+		},
+	},
+	{
+		name:    "check",
+		args:    "<zip>",
+		minArgs: 1,
+		maxArgs: 1,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+			c.registerOut(fs)
+			fs.BoolVar(&c.jsonOutput, "json", false, "print a JSON report with the hash and the checked files")
+			fs.BoolVar(&c.ratios, "ratios", false, "print the compression ratio of each file to stderr")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			output := outputNone
+			switch {
+			case c.jsonOutput:
+				output = outputJSON
+			case c.out != "":
+				output = outputHash
+			}
+			return c.check(zipFile, output)
+		},
+	},
+	{
+		name:    "list",
+		args:    "<zip>",
+		minArgs: 1,
+		maxArgs: 1,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+			fs.BoolVar(&c.print0, "print0", false, "terminate each entry with a NUL byte instead of a newline, e.g., for xargs -0")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			return c.check(zipFile, outputList)
+		},
+	},
+	{
+		name:    "stat",
+		args:    "<zip>",
+		minArgs: 1,
+		maxArgs: 1,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+			fs.BoolVar(&c.jsonOutput, "json", false, "print the statistics as a JSON object")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			return c.check(zipFile, outputStat)
+		},
+	},
+	{
+		name:    "extract",
+		args:    "<zip> <dir> [<prefix>]",
+		minArgs: 1,
+		maxArgs: 3,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+			c.registerExtract(fs)
+			fs.StringVar(&c.tarOut, "o", "", "write the files as a tar archive to `file`, gzip-compressed if it ends in .tar.gz or .tgz, in which case <dir> is omitted")
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			if c.tarOut != "" {
+				if len(args) > 2 {
+					return errors.New("usage: content_hash_unzip extract -o <file> [flags] <zip> [<prefix>]")
+				}
+				var prefix string
+				if len(args) == 2 {
+					prefix = args[1]
+				}
+				return c.extractTar(ctx, zipFile, c.tarOut, prefix)
+			}
+			if len(args) < 2 {
+				return errors.New("usage: content_hash_unzip extract [flags] <zip> <dir> [<prefix>]")
+			}
+			var prefix string
+			if len(args) == 3 {
+				prefix = args[2]
+			}
+			return c.extract(ctx, zipFile, args[1], prefix)
+		},
+	},
+	{
+		name:    "cat",
+		args:    "<zip> <path> [<prefix>]",
+		minArgs: 2,
+		maxArgs: 3,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			var prefix string
+			if len(args) == 3 {
+				prefix = args[2]
+			}
+			return c.cat(zipFile, args[1], prefix)
+		},
+	},
+	{
+		name:    "canonicalize",
+		args:    "<zip> <out.zip>",
+		minArgs: 2,
+		maxArgs: 2,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+			c.registerVerify(fs, true)
+			c.registerCheck(fs)
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			return c.canonicalize(zipFile, args[1])
+		},
+	},
+	{
+		name:    "diff",
+		args:    "<a.zip> <b.zip>",
+		minArgs: 2,
+		maxArgs: 2,
+		register: func(c *config, fs *flag.FlagSet) {
+			c.registerHash(fs)
+		},
+		run: func(ctx context.Context, c *config, zipFile string, args []string) error {
+			zipB, cleanup, err := c.localZip(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			return c.diff(zipFile, zipB, args[0], args[1])
+		},
+	},
 }
 
-func (e FileError) Unwrap() error {
-	return e.Err
+func run(ctx context.Context, args []string, defaults *flagDefaults) error {
+	if len(args) > 0 {
+		for _, cmd := range commands {
+			if args[0] == cmd.name {
+				return runCommand(ctx, cmd, args[1:], defaults)
+			}
+		}
+	}
+	return runLegacy(ctx, args, defaults)
 }
 
-// checkZip implements checkZip and also returns the *zip.Reader. This is
-// used in unzip to avoid redundant I/O.
-func checkZip(f *os.File) (*zip.Reader, error) {
-	// Check the total file size.
-	info, err := f.Stat()
-	if err != nil {
-		return nil, err
-	}
-	zipSize := info.Size()
-	if zipSize > MaxZipFile {
-		cf := CheckedFiles{SizeError: fmt.Errorf("zip file is too large (%d bytes; limit is %d bytes)", zipSize, MaxZipFile)}
-		return nil, cf.Err()
+func runCommand(ctx context.Context, cmd command, args []string, defaults *flagDefaults) (err error) {
+	var c config
+	fs := cmd.flagSet(&c)
+	if err := parseFlags(fs, args, defaults, cmd.name); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
 	}
-
-	// Check for valid file names, collisions.
-	var cf CheckedFiles
-	addError := func(zf *zip.File, err error) {
-		cf.Invalid = append(cf.Invalid, FileError{Path: zf.Name, Err: err})
+	if err := c.setupLogging(); err != nil {
+		return err
 	}
-	z, err := zip.NewReader(f, zipSize)
+	defer func() {
+		err = c.quietErr(err)
+	}()
+	args, err = c.fromTarArgs(fs.Args())
 	if err != nil {
-		return nil, err
+		return err
 	}
-	collisions := make(collisionChecker)
-	var size int64
-	for _, zf := range z.File {
-		name := zf.Name
-		isDir := strings.HasSuffix(name, "/")
-		if isDir {
-			name = name[:len(name)-1]
-		}
-		if path.Clean(name) != name {
-			addError(zf, fmt.Errorf("file path is not clean: %s", name))
-			continue
-		}
-		if err := module.CheckFilePath(name); err != nil {
-			addError(zf, err)
-			continue
-		}
-		if err := collisions.check(name, isDir); err != nil {
-			addError(zf, err)
-			continue
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "hash" && c.expectedHash == "" {
+			c.reportHash = true
 		}
-		if isDir {
-			continue
-		}
-		sz := int64(zf.UncompressedSize64)
-		if sz >= 0 && MaxZipFile-size >= sz {
-			size += sz
-		} else if cf.SizeError == nil {
-			cf.SizeError = fmt.Errorf("total uncompressed size of module contents too large (max size is %d bytes)", MaxZipFile)
-		}
-		cf.Valid = append(cf.Valid, zf.Name)
+	})
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		return fmt.Errorf("usage: content_hash_unzip %s [flags] %s", cmd.name, cmd.args)
+	}
+	if err := c.resolveEnvHash(); err != nil {
+		return err
 	}
+	if err := c.resolveSumLine(); err != nil {
+		return err
+	}
+	if err := c.resolveGoModPrefix(); err != nil {
+		return err
+	}
+	if cmd.noZip {
+		return cmd.run(ctx, &c, "", args)
+	}
+	zipFile, cleanup, err := c.inputZip(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return cmd.run(ctx, &c, zipFile, args)
+}
 
-	return z, cf.Err()
+// flagSet returns the flag set of cmd, which stores the flag values in c.
+func (cmd command) flagSet(c *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: content_hash_unzip %s [flags] %s\n", cmd.name, cmd.args)
+		fs.PrintDefaults()
+	}
+	c.registerCommon(fs)
+	cmd.register(c, fs)
+	return fs
 }
 
-// unzip extracts the contents of a module zip file to a directory.
-//
-// unzip checks all restrictions listed in the package documentation and returns
-// an error if the zip archive is not valid. In some cases, files may be written
-// to dir before an error is returned (for example, if a file's uncompressed
-// size does not match its declared size).
-//
-// dir may or may not exist: unzip will create it and any missing parent
-// directories if it doesn't exist. If dir exists, it must be empty.
-func unzip(dir string, zipFile string, prefix string) (err error) {
-	defer func() {
-		if err != nil {
-			err = &zipError{verb: "unzip", path: zipFile, err: err}
-		}
-	}()
+// legacyFlagSet returns the flag set of the positional command line that
+// predates the subcommands, which stores the flag values in c.
+func legacyFlagSet(c *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("content_hash_unzip", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	c.registerCommon(fs)
+	c.registerHash(fs)
+	c.registerVerify(fs, false)
+	c.registerCheck(fs)
+	c.registerExtract(fs)
+	c.registerOut(fs)
+	fs.BoolVar(&c.jsonOutput, "json", false, "in check mode, print a JSON report instead of the bare hash")
+	fs.BoolVar(&c.list, "list", false, "in check mode, print the size and path of each file instead of the hash")
+	fs.BoolVar(&c.print0, "print0", false, "with -list, terminate each entry with a NUL byte instead of a newline, e.g., for xargs -0")
+	fs.BoolVar(&c.filesHash, "files-hash", false, "in check mode, print the per-file SHA-256 lines that make up the h1 hash instead of the hash")
+	fs.BoolVar(&c.checkOnly, "check-only", false, "in check mode, only check the zip file without printing anything to stdout")
+	fs.StringVar(&c.extractPath, "extract", "", "write the contents of the file at `path` (relative to <strip_prefix>) to stdout, in which case <dir> is omitted")
+	return fs
+}
 
-	// Check that the directory is empty. Don't create it yet in case there's
-	// an error reading the zip.
-	if files, _ := os.ReadDir(dir); len(files) > 0 {
-		return fmt.Errorf("target directory %v exists and is not empty", dir)
+// parseFlags parses args with fs and then applies the defaults from the config
+// file, if any, to the flags that weren't given on the command line.
+func parseFlags(fs *flag.FlagSet, args []string, defaults *flagDefaults, command string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
+	return defaults.apply(fs, command)
+}
 
-	// Open the zip and check that it satisfies all restrictions.
-	f, err := os.Open(zipFile)
-	if err != nil {
+// runLegacy runs the positional command line that predates the subcommands.
+func runLegacy(ctx context.Context, args []string, defaults *flagDefaults) (err error) {
+	var c config
+	fs := legacyFlagSet(&c)
+	if err := parseFlags(fs, args, defaults, ""); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
 		return err
 	}
-	defer f.Close()
-	z, err := checkZip(f)
-	if err != nil {
+	if err := c.setupLogging(); err != nil {
 		return err
 	}
-
-	// unzip, enforcing sizes declared in the zip file.
-	if err := os.MkdirAll(dir, 0777); err != nil {
+	defer func() {
+		err = c.quietErr(err)
+	}()
+	args, err = c.fromTarArgs(fs.Args())
+	if err != nil {
 		return err
 	}
-	prefixMatched := false
-	for _, zf := range z.File {
-		name := zf.Name
-		if name == "" || strings.HasSuffix(name, "/") {
-			continue
-		}
-		if prefix != "" {
-			if !strings.HasPrefix(name, prefix+"/") {
-				continue
-			}
-			prefixMatched = true
-			name = strings.TrimPrefix(name, prefix+"/")
-		}
-		dst := filepath.Join(dir, name)
-		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
-			return err
-		}
-		// Mark all files as executable.
-		w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
-		if err != nil {
-			return err
+	if c.sumLine != "" {
+		if len(args) == 0 {
+			return errors.New(usage)
 		}
-		r, err := zf.Open()
-		if err != nil {
-			w.Close()
+		if err := c.resolveSumLine(); err != nil {
 			return err
 		}
-		lr := &io.LimitedReader{R: r, N: int64(zf.UncompressedSize64) + 1}
-		_, err = io.Copy(w, lr)
-		r.Close()
-		if err != nil {
-			w.Close()
+		// Insert the expected hash as if it had been passed as <hash>.
+		args = append([]string{args[0], c.expectedHash}, args[1:]...)
+	} else if len(args) >= 2 {
+		c.expectedHash = args[1]
+		c.reportHash = c.expectedHash == ""
+		if err := c.resolveEnvHash(); err != nil {
 			return err
 		}
-		if err := w.Close(); err != nil {
-			return err
-		}
-		if lr.N <= 0 {
-			return fmt.Errorf("uncompressed size of file %s is larger than declared size (%d bytes)", zf.Name, zf.UncompressedSize64)
+	}
+	switch {
+	case c.extractPath != "":
+		if len(args) < 1 || len(args) > 3 {
+			return errors.New(usage)
 		}
+	case len(args) < 1 || len(args) > 4 || len(args) == 2 && c.sumLine == "":
+		return errors.New(usage)
 	}
 
-	if prefix != "" && !prefixMatched {
-		return fmt.Errorf("no file matched prefix %q", prefix)
+	zipFile, cleanup, err := c.inputZip(ctx, args[0])
+	if err != nil {
+		return err
 	}
+	defer cleanup()
 
-	return nil
+	if c.extractPath != "" {
+		var prefix string
+		if len(args) == 3 {
+			prefix = args[2]
+		}
+		return c.cat(zipFile, c.extractPath, prefix)
+	}
+	if len(args) <= 2 {
+		output := outputHash
+		switch {
+		case c.checkOnly && len(args) == 1:
+			output = outputNone
+		case c.list:
+			output = outputList
+		case c.filesHash:
+			output = outputFilesHash
+		case c.jsonOutput:
+			output = outputJSON
+		}
+		return c.check(zipFile, output)
+	}
+	var prefix string
+	if len(args) == 4 {
+		prefix = args[3]
+	}
+	return c.extract(ctx, zipFile, args[2], prefix)
 }
 
-// collisionChecker finds case-insensitive name collisions and paths that
-// are listed as both files and directories.
-//
-// The keys of this map are processed with strToFold. pathInfo has the original
-// path for each folded path.
-type collisionChecker map[string]pathInfo
+// printList prints the uncompressed size and path of each valid file in z.
+// Entries are terminated by a NUL byte instead of a newline if print0 is set,
+// as with find -print0.
+func printList(z *zip.Reader, cf contenthash.CheckedFiles, print0 bool) {
+	sizes := make(map[string]uint64, len(z.File))
+	for _, zf := range z.File {
+		sizes[zf.Name] = zf.UncompressedSize64
+	}
+	term := "\n"
+	if print0 {
+		term = "\x00"
+	}
+	for _, name := range cf.Valid {
+		fmt.Printf("%d\t%s%s", sizes[name], name, term)
+	}
+}
 
-type pathInfo struct {
-	path  string
-	isDir bool
+// archiveStats describes the contents of a zip file.
+type archiveStats struct {
+	Files             int     `json:"files"`
+	Directories       int     `json:"directories"`
+	CompressedBytes   uint64  `json:"compressedBytes"`
+	UncompressedBytes uint64  `json:"uncompressedBytes"`
+	Ratio             float64 `json:"ratio"`
+	LargestFile       string  `json:"largestFile"`
+	LargestFileBytes  uint64  `json:"largestFileBytes"`
 }
 
-func (cc collisionChecker) check(p string, isDir bool) error {
-	fold := strToFold(p)
-	if other, ok := cc[fold]; ok {
-		if p != other.path {
-			return fmt.Errorf("case-insensitive file name collision: %q and %q", other.path, p)
-		}
-		if isDir != other.isDir {
-			return fmt.Errorf("entry %q is both a file and a directory", p)
+// printArchiveStats prints statistics about the files in z as aligned
+// key-value lines or, if asJSON is set, as a JSON object. Directories include
+// those that are only implied by the paths of files.
+func printArchiveStats(z *zip.Reader, asJSON bool) error {
+	var s archiveStats
+	dirs := make(map[string]bool)
+	for _, zf := range z.File {
+		name := strings.TrimSuffix(zf.Name, "/")
+		if name != zf.Name {
+			dirs[name] = true
+		} else {
+			s.Files++
+			s.CompressedBytes += zf.CompressedSize64
+			s.UncompressedBytes += zf.UncompressedSize64
+			if s.LargestFile == "" || zf.UncompressedSize64 > s.LargestFileBytes {
+				s.LargestFile = zf.Name
+				s.LargestFileBytes = zf.UncompressedSize64
+			}
 		}
-		if !isDir {
-			return fmt.Errorf("multiple entries for file %q", p)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
 		}
-		// It's not an error if check is called with the same directory multiple
-		// times. check is called recursively on parent directories, so check
-		// may be called on the same directory many times.
-	} else {
-		cc[fold] = pathInfo{path: p, isDir: isDir}
 	}
-
-	if parent := path.Dir(p); parent != "." {
-		return cc.check(parent, true)
+	s.Directories = len(dirs)
+	if s.CompressedBytes > 0 {
+		s.Ratio = float64(s.UncompressedBytes) / float64(s.CompressedBytes)
 	}
-	return nil
-}
-
-type zipError struct {
-	verb, path string
-	err        error
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "files:\t%d\n", s.Files)
+	fmt.Fprintf(w, "directories:\t%d\n", s.Directories)
+	fmt.Fprintf(w, "compressed size:\t%d\n", s.CompressedBytes)
+	fmt.Fprintf(w, "uncompressed size:\t%d\n", s.UncompressedBytes)
+	fmt.Fprintf(w, "compression ratio:\t%.2f:1\n", s.Ratio)
+	if s.LargestFile != "" {
+		fmt.Fprintf(w, "largest file:\t%s (%d bytes)\n", s.LargestFile, s.LargestFileBytes)
+	}
+	return w.Flush()
 }
 
-func (e *zipError) Error() string {
-	if e.path == "" {
-		return fmt.Sprintf("%s: %v", e.verb, e.err)
-	} else {
-		return fmt.Sprintf("%s %s: %v", e.verb, e.path, e.err)
-	}
+// report is the JSON representation of the result of checking a zip file.
+type report struct {
+	Hash         string       `json:"hash"`
+	Valid        []string     `json:"valid"`
+	Omitted      []fileReport `json:"omitted"`
+	Invalid      []fileReport `json:"invalid"`
+	SizeError    string       `json:"sizeError,omitempty"`
+	ArchiveError string       `json:"archiveError,omitempty"`
 }
 
-func (e *zipError) Unwrap() error {
-	return e.err
+type fileReport struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
-// strToFold returns a string with the property that
-//
-//	strings.EqualFold(s, t) iff strToFold(s) == strToFold(t)
-//
-// This lets us test a large set of strings for fold-equivalent
-// duplicates without making a quadratic number of calls
-// to EqualFold. Note that strings.ToUpper and strings.ToLower
-// do not have the desired property in some corner cases.
-func strToFold(s string) string {
-	// Fast path: all ASCII, no upper case.
-	// Most paths look like this already.
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if c >= utf8.RuneSelf || 'A' <= c && c <= 'Z' {
-			goto Slow
-		}
+func printReport(hash string, cf contenthash.CheckedFiles) error {
+	r := report{
+		Hash:    hash,
+		Valid:   cf.Valid,
+		Omitted: fileReports(cf.Omitted),
+		Invalid: fileReports(cf.Invalid),
+	}
+	if r.Valid == nil {
+		r.Valid = []string{}
 	}
-	return s
+	if cf.SizeError != nil {
+		r.SizeError = cf.SizeError.Error()
+	}
+	if cf.ArchiveError != nil {
+		r.ArchiveError = cf.ArchiveError.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
 
-Slow:
-	var buf bytes.Buffer
-	for _, r := range s {
-		// SimpleFold(x) cycles to the next equivalent rune > x
-		// or wraps around to smaller values. Iterate until it wraps,
-		// and we've found the minimum value.
-		for {
-			r0 := r
-			r = unicode.SimpleFold(r0)
-			if r <= r0 {
-				break
-			}
-		}
-		// Exception to allow fast path above: A-Z => a-z
-		if 'A' <= r && r <= 'Z' {
-			r += 'a' - 'A'
-		}
-		buf.WriteRune(r)
+func fileReports(errs []contenthash.FileError) []fileReport {
+	reports := make([]fileReport, 0, len(errs))
+	for _, e := range errs {
+		reports = append(reports, fileReport{Path: e.Path, Error: e.Err.Error()})
 	}
-	return buf.String()
+	return reports
 }
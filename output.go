@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+)
+
+// writeFileAtomic creates the file at path with the contents written by write.
+// The contents are written to a temporary file in the same directory, which is
+// renamed into place on success, so that readers never observe a partially
+// written file.
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := write(f); err != nil {
+		return err
+	}
+	// CreateTemp creates the file with mode 0600.
+	if err := f.Chmod(0644); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// sortByPath returns a copy of files sorted by path so that output doesn't
+// depend on the order of extraction.
+func sortByPath(files []contenthash.ExtractedFile) []contenthash.ExtractedFile {
+	files = append([]contenthash.ExtractedFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// writeChecksums writes the SHA-256 digest and path of each file to the file
+// at path in the format of sha256sum.
+func writeChecksums(path string, files []contenthash.ExtractedFile) error {
+	files = sortByPath(files)
+	return writeFileAtomic(path, func(w io.Writer) error {
+		for _, f := range files {
+			if _, err := fmt.Fprintf(w, "%s  %s\n", f.SHA256, f.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// manifestEntry is the JSON representation of an extracted file in the
+// manifest.
+type manifestEntry struct {
+	// Name is the name of the file in the zip.
+	Name string `json:"name"`
+	// Path is the slash-separated path the file was extracted to, relative
+	// to the target directory.
+	Path string `json:"path"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+	// Mode holds the permission bits of the file in octal, e.g., "0444".
+	Mode string `json:"mode"`
+	// SHA256 is the hex-encoded SHA-256 digest of the contents of the file,
+	// computed while extracting it.
+	SHA256 string `json:"sha256"`
+}
+
+// writeManifest writes a JSON array with an entry for each file, sorted by
+// path, to the file at path.
+func writeManifest(path string, files []contenthash.ExtractedFile) error {
+	entries := make([]manifestEntry, 0, len(files))
+	for _, f := range sortByPath(files) {
+		entries = append(entries, manifestEntry{
+			Name:   f.Name,
+			Path:   f.Path,
+			Size:   f.Size,
+			Mode:   fmt.Sprintf("%04o", f.Mode.Perm()),
+			SHA256: f.SHA256,
+		})
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	})
+}
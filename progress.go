@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressPrinter prints the extraction progress as a percentage. On a
+// terminal, the same line is updated in place; otherwise, a new line is
+// printed periodically.
+type progressPrinter struct {
+	w        io.Writer
+	terminal bool
+	interval time.Duration
+	last     time.Time
+	printed  bool
+}
+
+func newProgressPrinter(f *os.File) *progressPrinter {
+	p := &progressPrinter{w: f, interval: time.Second}
+	if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.terminal = true
+		p.interval = 200 * time.Millisecond
+	}
+	return p
+}
+
+func (p *progressPrinter) update(written, total int64) {
+	now := time.Now()
+	if written < total && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	percent := int64(100)
+	if total > 0 {
+		percent = written * 100 / total
+	}
+	if p.terminal {
+		fmt.Fprintf(p.w, "\rextracting: %3d%%", percent)
+	} else {
+		fmt.Fprintf(p.w, "extracting: %d%% (%d/%d bytes)\n", percent, written, total)
+	}
+	p.printed = true
+}
+
+// done terminates the in-place progress line on a terminal.
+func (p *progressPrinter) done() {
+	if p.terminal && p.printed {
+		fmt.Fprintln(p.w)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fmeum/content_hash_unzip/contenthash"
+)
+
+// maxBinSize is the size above which the no-large-bin rule rejects .bin files.
+const maxBinSize = 1 << 20
+
+// rules are the validators that can be enabled with -rule.
+var rules = map[string]contenthash.Validator{
+	"no-large-bin": func(name string, size int64, mode fs.FileMode) error {
+		if path.Ext(name) == ".bin" && size > maxBinSize {
+			return fmt.Errorf(".bin files must not be larger than %d bytes (%d bytes)", maxBinSize, size)
+		}
+		return nil
+	},
+	"no-executables": func(name string, size int64, mode fs.FileMode) error {
+		if mode&0111 != 0 {
+			return fmt.Errorf("executable files are not allowed (mode %v)", mode)
+		}
+		return nil
+	},
+}
+
+// ruleList is a flag.Value for the names of rules, which may be given
+// multiple times.
+type ruleList []string
+
+func (l *ruleList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ruleList) Set(s string) error {
+	if _, ok := rules[s]; !ok {
+		return fmt.Errorf("unknown rule %q, must be one of %s", s, strings.Join(ruleNames(), ", "))
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// validators returns the validators of the rules in l.
+func (l ruleList) validators() []contenthash.Validator {
+	vs := make([]contenthash.Validator, 0, len(l))
+	for _, name := range l {
+		vs = append(vs, rules[name])
+	}
+	return vs
+}
+
+func ruleNames() []string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}